@@ -0,0 +1,442 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gorp "gopkg.in/gorp.v1"
+)
+
+// 审计记录的操作
+const (
+	AuditCreate     = "create"
+	AuditUpdate     = "update"
+	AuditUpsert     = "upsert"
+	AuditDelete     = "delete"
+	AuditRestore    = "restore"
+	AuditHardDelete = "hardDelete"
+)
+
+// ErrAuditBypass 不经过CommonModel写入启用审计的表，需改用model的方法或tx.Model获取的model
+var ErrAuditBypass = errors.New("audited model must be written through CommonModel")
+
+// auditedTypes 启用审计的结构体，key为auditKey
+var auditedTypes sync.Map
+
+// writeTablePattern 匹配insert、replace、update、delete语句写入的表
+var writeTablePattern = regexp.MustCompile("(?is)^\\s*(?:insert\\s+(?:ignore\\s+)?into|replace\\s+into|update(?:\\s+ignore)?|delete\\s+from)\\s+([`\"\\w.]+)")
+
+// auditedCtxKey 标记语句由启用审计的CommonModel发出
+type auditedCtxKey struct{}
+
+type auditKey struct {
+	dbMap   *gorp.DbMap
+	objType reflect.Type
+}
+
+// AuditRecord 一条记录的一次修改
+type AuditRecord struct {
+	Table  string
+	RowId  int
+	Action string
+	Actor  string
+	// 修改前的值，只包含变化的字段，新增时为nil，物理删除时为整条记录
+	Before map[string]interface{}
+	// 修改后的值，只包含变化的字段，新增时为整条记录，物理删除时为nil
+	After map[string]interface{}
+	Time  time.Time
+}
+
+// AuditSink 审计记录的写入，exec为执行修改的事务，写入数据表时可与修改一起提交
+type AuditSink interface {
+	WriteAudit(exec gorp.SqlExecutor, records []*AuditRecord) error
+}
+
+// AuditSinkFunc 函数形式的AuditSink
+type AuditSinkFunc func(exec gorp.SqlExecutor, records []*AuditRecord) error
+
+// WriteAudit WriteAudit.
+func (this AuditSinkFunc) WriteAudit(exec gorp.SqlExecutor, records []*AuditRecord) error {
+	return this(exec, records)
+}
+
+// AuditOptions 审计配置
+type AuditOptions struct {
+	// 审计记录的写入，必填
+	Sink AuditSink
+	// 不记录的字段，如密码
+	ExcludeColumns []string
+	// 从model绑定的context中获取操作人，默认使用WithActor设置的值
+	Actor func(ctx context.Context) string
+}
+
+type actorKey struct{}
+
+// WithActor 在context中设置操作人，通过WithContext绑定到model后记录到审计中
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorOf 获取WithActor设置的操作人
+func ActorOf(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// SetAudit 启用审计，CommonModel的修改方法（Create、UpdateModel、UpdateById、UpdateByCondition、DeleteById、RestoreById、
+// HardDeleteById、PurgeDeleted、CreateBatch、Upsert、UpdateBatchById及其Context版本）在事务中查询修改前的值，并与修改一起写入审计记录.
+// 修改前的值不加锁读取，options为nil时关闭审计.
+// 连接池经过WrapConnector包装时（mysql、sqlite、postgres模块已包装），不经过CommonModel写入该表的语句返回ErrAuditBypass，
+// 包括DbMap()、Executor()返回的对象的Insert、Update、Delete、Exec与手写sql；按语句开头的insert、replace、update、delete识别写入的表，
+// with等其他形式的写入不做检查。未包装的连接池只有Tx的Insert、Update、Delete会返回ErrAuditBypass.
+// e.g.
+//
+//	userModel.SetAudit(&model.AuditOptions{Sink: model.NewAuditTableSink("audit_log", model.MysqlDialect), ExcludeColumns: []string{"password"}})
+//	user := model.WithContext(userModel, model.WithActor(ctx, "admin")).(*UserModel)
+//	user.UpdateById(map[string]interface{}{"name": "a"}, 1)
+func (this *CommonModel) SetAudit(options *AuditOptions) {
+	if options != nil && options.Sink == nil {
+		panic("audit sink must be set")
+	}
+	this.audit = options
+	this.registerAudit(this.dbMap)
+}
+
+// registerAudit 记录dbMap中启用审计的结构体与表，供Tx与连接拒绝绕过审计的写入
+func (this *CommonModel) registerAudit(dbMap *gorp.DbMap) {
+	if dbMap == nil || this.objType == nil {
+		return
+	}
+	key := auditKey{dbMap: dbMap, objType: this.objType}
+	d, _ := dbMap.Db.Driver().(*contextDriver)
+	table, err := dbMap.TableFor(this.objType, false)
+	if err != nil {
+		d = nil
+	}
+	if this.audit != nil {
+		auditedTypes.Store(key, true)
+		if d != nil {
+			d.setAudited(table.TableName, true)
+		}
+	} else {
+		auditedTypes.Delete(key)
+		if d != nil {
+			d.setAudited(table.TableName, false)
+		}
+	}
+}
+
+// setAudited 设置表是否启用审计
+func (this *contextDriver) setAudited(table string, audited bool) {
+	table = strings.ToLower(table)
+	if audited {
+		if _, loaded := this.audited.LoadOrStore(table, true); !loaded {
+			atomic.AddInt32(&this.auditedCount, 1)
+		}
+	} else if _, loaded := this.audited.LoadAndDelete(table); loaded {
+		atomic.AddInt32(&this.auditedCount, -1)
+	}
+}
+
+// checkWrite query写入启用审计的表且不是由启用审计的CommonModel发出时返回ErrAuditBypass
+func (this *contextDriver) checkWrite(ctx context.Context, query string) error {
+	if atomic.LoadInt32(&this.auditedCount) == 0 || ctx.Value(auditedCtxKey{}) != nil {
+		return nil
+	}
+	match := writeTablePattern.FindStringSubmatch(query)
+	if match == nil {
+		return nil
+	}
+	table := match[1][strings.LastIndex(match[1], ".")+1:]
+	table = strings.ToLower(strings.Trim(table, "`\""))
+	if _, ok := this.audited.Load(table); ok {
+		return fmt.Errorf("%w: %v", ErrAuditBypass, table)
+	}
+	return nil
+}
+
+// checkAuditBypass list中有启用审计的结构体时返回ErrAuditBypass
+func checkAuditBypass(dbMap *gorp.DbMap, list []interface{}) error {
+	for _, obj := range list {
+		t := reflect.TypeOf(obj)
+		for t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if _, ok := auditedTypes.Load(auditKey{dbMap: dbMap, objType: t}); ok {
+			return fmt.Errorf("%w: %v", ErrAuditBypass, t)
+		}
+	}
+	return nil
+}
+
+// auditTx 启用审计且未绑定事务时在事务中执行fn，fn中的m为绑定到事务的model
+func (this *CommonModel) auditTx(fn func(m *CommonModel) (int, error)) (int, error) {
+	if this.audit == nil || this.tx != nil {
+		return fn(this)
+	}
+	var res int
+	err := this.Transaction(func(tx *Tx) error {
+		var err error
+		res, err = fn(tx.Model(this.GetModel()).(commonGetter).common())
+		return err
+	})
+	return res, err
+}
+
+// auditRows 查询满足条件的记录修改前的值，未启用审计时返回nil
+func (this *CommonModel) auditRows(cond Cond) ([]map[string]interface{}, error) {
+	if this.audit == nil {
+		return nil, nil
+	}
+	if this.objType == nil {
+		return nil, errors.New("audit requires the model to be initialized by CommonModel.Initer")
+	}
+	list := reflect.New(reflect.SliceOf(reflect.PtrTo(this.objType)))
+	sql, params := Select(this.GetFields()).From(this.GetModel().GetTable()).Where(cond).ToSql(this.Dialect())
+	if _, err := this.executor().Select(list.Interface(), sql, params...); err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]interface{}, 0, list.Elem().Len())
+	for i := 0; i < list.Elem().Len(); i++ {
+		rows = append(rows, structToMap(list.Elem().Index(i)))
+	}
+	return rows, nil
+}
+
+// auditChanges 记录修改，fieldsOf返回id对应记录修改的字段
+func (this *CommonModel) auditChanges(action string, before []map[string]interface{}, fieldsOf func(id int) map[string]interface{}) error {
+	if this.audit == nil {
+		return nil
+	}
+	records := make([]*AuditRecord, 0, len(before))
+	for _, row := range before {
+		id := int(toInt64(row["id"]))
+		oldValues := make(map[string]interface{})
+		newValues := make(map[string]interface{})
+		for field, value := range fieldsOf(id) {
+			old, ok := lookupField(row, field)
+			if this.excluded(field) || (ok && sameValue(old, value)) {
+				continue
+			}
+			oldValues[field] = old
+			newValues[field] = value
+		}
+		if len(newValues) == 0 {
+			continue
+		}
+		records = append(records, this.newAuditRecord(action, id, oldValues, newValues))
+	}
+	return this.writeAudit(records)
+}
+
+// auditCreated 记录新增，objs为结构体指针
+func (this *CommonModel) auditCreated(action string, objs ...interface{}) error {
+	if this.audit == nil {
+		return nil
+	}
+	records := make([]*AuditRecord, 0, len(objs))
+	for _, obj := range objs {
+		row := this.withoutExcluded(structToMap(reflect.ValueOf(obj)))
+		records = append(records, this.newAuditRecord(action, int(toInt64(row["id"])), nil, row))
+	}
+	return this.writeAudit(records)
+}
+
+// auditDeleted 记录物理删除
+func (this *CommonModel) auditDeleted(before []map[string]interface{}) error {
+	if this.audit == nil {
+		return nil
+	}
+	records := make([]*AuditRecord, 0, len(before))
+	for _, row := range before {
+		records = append(records, this.newAuditRecord(AuditHardDelete, int(toInt64(row["id"])), this.withoutExcluded(row), nil))
+	}
+	return this.writeAudit(records)
+}
+
+func (this *CommonModel) newAuditRecord(action string, id int, before map[string]interface{}, after map[string]interface{}) *AuditRecord {
+	actor := ActorOf
+	if this.audit.Actor != nil {
+		actor = this.audit.Actor
+	}
+	return &AuditRecord{
+		Table:  this.GetModel().GetTable(),
+		RowId:  id,
+		Action: action,
+		Actor:  actor(this.Context()),
+		Before: before,
+		After:  after,
+		Time:   time.Now(),
+	}
+}
+
+func (this *CommonModel) writeAudit(records []*AuditRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return this.audit.Sink.WriteAudit(this.Executor(), records)
+}
+
+func (this *CommonModel) excluded(field string) bool {
+	for _, column := range this.audit.ExcludeColumns {
+		if strings.EqualFold(column, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *CommonModel) withoutExcluded(row map[string]interface{}) map[string]interface{} {
+	for field := range row {
+		if this.excluded(field) {
+			delete(row, field)
+		}
+	}
+	return row
+}
+
+// structToMap 按db标签将结构体转换为map，包括匿名嵌入的结构体
+func structToMap(v reflect.Value) map[string]interface{} {
+	v = reflect.Indirect(v)
+	row := make(map[string]interface{})
+	for i := 0; i < v.NumField(); i++ {
+		fieldT := v.Type().Field(i)
+		tag := fieldT.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		if fieldT.Anonymous && tag == "" && fieldT.Type.Kind() == reflect.Struct {
+			for field, value := range structToMap(v.Field(i)) {
+				row[field] = value
+			}
+			continue
+		}
+		if tag == "" || fieldT.PkgPath != "" {
+			continue
+		}
+		row[tag] = v.Field(i).Interface()
+	}
+	return row
+}
+
+// lookupField 不区分大小写查找字段，与gorp的列映射一致
+func lookupField(row map[string]interface{}, field string) (interface{}, bool) {
+	if value, ok := row[field]; ok {
+		return value, true
+	}
+	for key, value := range row {
+		if strings.EqualFold(key, field) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// sameValue 比较修改前后的值，数字类型不同时按字面值比较
+func sameValue(a interface{}, b interface{}) bool {
+	a, b = derefValue(a), derefValue(b)
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func derefValue(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		return v.Elem().Interface()
+	}
+	return value
+}
+
+// AuditTableSink 将审计记录写入数据表，与修改在同一事务中提交，before与after为json
+// 表结构（mysql）：
+//
+//	create table audit_log (
+//		id bigint not null auto_increment primary key,
+//		tableName varchar(64) not null,
+//		rowId bigint not null,
+//		action varchar(16) not null,
+//		actor varchar(64) not null,
+//		beforeData text,
+//		afterData text,
+//		createdTime datetime not null
+//	)
+type AuditTableSink struct {
+	table   string
+	dialect Dialect
+}
+
+// NewAuditTableSink table为审计表名，dialect为审计表所在数据库的Dialect
+func NewAuditTableSink(table string, dialect Dialect) *AuditTableSink {
+	return &AuditTableSink{table: table, dialect: dialect}
+}
+
+// WriteAudit 批量插入审计记录
+func (this *AuditTableSink) WriteAudit(exec gorp.SqlExecutor, records []*AuditRecord) error {
+	columns := []string{"tableName", "rowId", "action", "actor", "beforeData", "afterData", "createdTime"}
+	size := batchSize(this.dialect, 0, len(columns))
+	for start := 0; start < len(records); start += size {
+		end := start + size
+		if end > len(records) {
+			end = len(records)
+		}
+		b := newSqlBuilder(this.dialect)
+		b.write("insert into ", quoteTable(b.dialect, this.table), " (")
+		for i, column := range columns {
+			if i > 0 {
+				b.write(",")
+			}
+			b.write(b.quote(column))
+		}
+		b.write(") values ")
+		for i, record := range records[start:end] {
+			before, err := marshalAudit(record.Before)
+			if err != nil {
+				return err
+			}
+			after, err := marshalAudit(record.After)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				b.write(",")
+			}
+			b.write("(")
+			for j, value := range []interface{}{record.Table, record.RowId, record.Action, record.Actor, before, after, record.Time} {
+				if j > 0 {
+					b.write(",")
+				}
+				b.value(value)
+			}
+			b.write(")")
+		}
+		if _, err := exec.Exec(b.String(), b.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func marshalAudit(values map[string]interface{}) (interface{}, error) {
+	if values == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	gorp "gopkg.in/gorp.v1"
+)
+
+func TestAuditRejectsDirectWrites(t *testing.T) {
+	m := newSoftDeleteUserModel(t)
+	var records []*AuditRecord
+	m.SetAudit(&AuditOptions{Sink: AuditSinkFunc(func(exec gorp.SqlExecutor, list []*AuditRecord) error {
+		records = append(records, list...)
+		return nil
+	})})
+	dbMap := m.DbMap()
+	if err := dbMap.Insert(&softDeleteUser{Name: "c"}); !errors.Is(err, ErrAuditBypass) {
+		t.Fatalf("insert: %v", err)
+	}
+	if _, err := dbMap.Exec("update `user` set name = ?", "x"); !errors.Is(err, ErrAuditBypass) {
+		t.Fatalf("exec: %v", err)
+	}
+	err := Transaction(dbMap, func(tx *Tx) error {
+		_, err := tx.Exec("delete from user where id = ?", 1)
+		return err
+	})
+	if !errors.Is(err, ErrAuditBypass) {
+		t.Fatalf("tx exec: %v", err)
+	}
+	if _, err = m.UpdateById(map[string]interface{}{"name": "a2"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Action != AuditUpdate {
+		t.Fatalf("records: %v", records)
+	}
+	m.SetAudit(nil)
+	if _, err = dbMap.Exec("update `user` set name = ?", "x"); err != nil {
+		t.Fatalf("exec after audit disabled: %v", err)
+	}
+}
@@ -1,10 +1,11 @@
 package model
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"reflect"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
@@ -31,6 +32,28 @@ type CommonModel struct {
 
 	fields string
 	model  Model
+	tx     *Tx
+
+	replicas   *ReplicaSet
+	usePrimary bool
+	versioned  bool
+
+	softDelete bool
+	// 通过SetSoftDelete显式设置后，SetFields不再按字段启用软删除
+	softDeleteSet  bool
+	hasDeletedTime bool
+	scope          deleteScope
+
+	ctx     context.Context
+	audit   *AuditOptions
+	objType reflect.Type
+	cache   *modelCache
+	timeout time.Duration
+}
+
+// commonGetter 嵌入CommonModel的model均实现该接口
+type commonGetter interface {
+	common() *CommonModel
 }
 
 // BaseModel BaseModel.
@@ -48,6 +71,17 @@ type LogModel struct {
 	CreatedTime time.Time `db:"createdTime" json:"createdTime"`
 }
 
+// VersionModel 带乐观锁的BaseModel，插入时Version为1，每次更新加1.
+// 通过gorp的Update更新时按Version检查，通过UpdateById、UpdateByCondition更新时在fields中传入version作为期望的版本号，冲突时返回VersionConflictError.
+type VersionModel struct {
+	SimpleModel
+	Id          int       `db:"id" json:"id"`
+	CreatedTime time.Time `db:"createdTime" json:"createdTime"`
+	IsDeleted   int       `db:"isDeleted" json:"isDeleted"`
+	UpdatedTime time.Time `db:"updatedTime" json:"updatedTime"`
+	Version     int64     `db:"version" json:"version"`
+}
+
 type SimpleModel struct {
 }
 
@@ -77,6 +111,98 @@ func (this *CommonModel) Db() *sql.DB {
 	return this.db
 }
 
+// Executor 执行sql的对象，通过Tx.Model绑定事务后为事务，否则为DbMap.
+func (this *CommonModel) Executor() gorp.SqlExecutor {
+	if this.tx != nil {
+		return this.tx.Transaction
+	}
+	return this.DbMap()
+}
+
+// Tx 绑定的事务，未绑定时为nil.
+func (this *CommonModel) Tx() *Tx {
+	return this.tx
+}
+
+// Transaction 在当前model所在数据库的事务中执行fn，已绑定事务时使用savepoint嵌套，绑定了context时使用TransactionContext.
+func (this *CommonModel) Transaction(fn func(tx *Tx) error, options ...*TxOptions) error {
+	if this.tx != nil {
+		return this.tx.Nested(fn)
+	}
+	return TransactionContext(this.Context(), this.DbMap(), fn, options...)
+}
+
+func (this *CommonModel) common() *CommonModel {
+	return this
+}
+
+// cloneModel 复制model，用于绑定事务或强制读主库
+func cloneModel(model Model) Model {
+	if _, ok := model.(commonGetter); !ok {
+		panic(fmt.Sprintf("model %T must embed CommonModel", model))
+	}
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("model %T must be a pointer to struct", model))
+	}
+	copied := reflect.New(v.Elem().Type())
+	copied.Elem().Set(v.Elem())
+	view := copied.Interface().(Model)
+	view.SetModel(view)
+	return view
+}
+
+// SetReplicas 设置从库，查询类方法（GetInterfaceById、SearchInterface、SearchAllInterface、SelectQuery、CountQuery）默认读从库.
+func (this *CommonModel) SetReplicas(replicas *ReplicaSet) {
+	this.replicas = replicas
+}
+
+// Replicas 从库.
+func (this *CommonModel) Replicas() *ReplicaSet {
+	return this.replicas
+}
+
+// UsePrimary 获取强制读主库的model副本，用于写入后立即读取.
+// e.g.
+//
+//	user := model.UsePrimary(userModel).(*UserModel)
+func UsePrimary(model Model) Model {
+	view := cloneModel(model)
+	view.(commonGetter).common().usePrimary = true
+	return view
+}
+
+// WithContext 获取绑定ctx的model副本，语句通过database/sql的Context方法执行，ctx取消时中断执行中的语句，审计从ctx中获取操作人.
+// e.g.
+//
+//	user := model.WithContext(userModel, model.WithActor(ctx, "admin")).(*UserModel)
+func WithContext(model Model, ctx context.Context) Model {
+	view := cloneModel(model)
+	view.(commonGetter).common().ctx = ctx
+	return view
+}
+
+// Context 绑定的context，未绑定时为context.Background().
+func (this *CommonModel) Context() context.Context {
+	if this.ctx == nil {
+		return context.Background()
+	}
+	return this.ctx
+}
+
+// Reader 执行查询的对象，绑定事务时为事务，有健康的从库且未强制读主库时为从库，否则为主库.
+func (this *CommonModel) Reader() gorp.SqlExecutor {
+	if this.tx != nil {
+		return this.tx.Transaction
+	}
+	if !this.usePrimary {
+		if dbMap := this.replicas.pick(); dbMap != nil {
+			return dbMap
+		}
+	}
+	return this.DbMap()
+}
+
 func (this *CommonModel) GetTable() string {
 	panic("Func GetTable must be implemented")
 }
@@ -89,8 +215,13 @@ func (this *CommonModel) GetModel() Model {
 	return this.model
 }
 
+// SetFields 设置查询的字段，包含isDeleted时默认启用软删除（可通过SetSoftDelete(false)关闭），包含deletedTime时删除时记录删除时间.
 func (this *CommonModel) SetFields(fields string) {
 	this.fields = fields
+	if !this.softDeleteSet {
+		this.softDelete = hasField(fields, "isDeleted")
+	}
+	this.hasDeletedTime = hasField(fields, "deletedTime")
 }
 
 func (this *CommonModel) GetFields() string {
@@ -98,77 +229,82 @@ func (this *CommonModel) GetFields() string {
 }
 
 func (this *CommonModel) Initer(dbMap *gorp.DbMap, obj interface{}, tableName string) error {
-	dbMap.AddTableWithName(obj, tableName).SetKeys(true, "id")
+	table := dbMap.AddTableWithName(obj, tableName).SetKeys(true, "id")
+	this.objType = reflect.TypeOf(obj)
+	if this.objType.Kind() == reflect.Ptr {
+		this.objType = this.objType.Elem()
+	}
+	if isVersioned(obj) {
+		table.SetVersionCol("version")
+		this.versioned = true
+	}
+	this.registerAudit(dbMap)
 	return nil
 }
 
 // Create Create.
 func (this *CommonModel) Create(model interface{}) (int, error) {
-	m := reflect.ValueOf(model).Elem()
-	err := this.DbMap().Insert(model)
-	if err != nil {
-		return 0, err
-	}
+	return this.auditTx(func(c *CommonModel) (int, error) {
+		m := reflect.ValueOf(model).Elem()
+		err := c.insert(model)
+		if err != nil {
+			return 0, err
+		}
+
+		id := m.FieldByName("Id").Interface().(int)
+		c.InvalidateCache(id)
+		return id, c.auditCreated(AuditCreate, model)
+	})
+}
 
-	return m.FieldByName("Id").Interface().(int), nil
+// UpdateModel 按主键更新整条记录，调用PreUpdate等hook，嵌入VersionModel时版本号不一致返回VersionConflictError.
+func (this *CommonModel) UpdateModel(model interface{}) (int, error) {
+	return this.auditTx(func(c *CommonModel) (int, error) {
+		id := reflect.ValueOf(model).Elem().FieldByName("Id").Interface().(int)
+		before, err := c.auditRows(Eq("id", id))
+		if err != nil {
+			return 0, err
+		}
+		rows, err := c.updateStruct(model)
+		if err != nil || rows == 0 {
+			return rows, err
+		}
+		c.InvalidateCache(id)
+		after := structToMap(reflect.ValueOf(model))
+		return rows, c.auditChanges(AuditUpdate, before, func(int) map[string]interface{} { return after })
+	})
 }
 
 // UpdateById UpdateById.
 func (this *CommonModel) UpdateById(fields map[string]interface{}, id ...int) (int, error) {
-	if _, ok := fields["updatedTime"]; !ok {
-		fields["updatedTime"] = time.Now()
-	}
-
-	ids := []string{}
+	keys := make([]interface{}, 0, len(id))
 	for _, item := range id {
-		ids = append(ids, strconv.Itoa(item))
-	}
-	update := ""
-	params := []interface{}{}
-	for field, value := range fields {
-		coma := ""
-		if update != "" {
-			coma = ","
-		}
-		params = append(params, value)
-		update += fmt.Sprintf("%v `%v`=?", coma, field)
-	}
-	sql := fmt.Sprintf("update %v set %v where `id` in (%v) and `isDeleted`=0", this.GetModel().GetTable(), update, strings.Join(ids, ","))
-	result, err := this.DbMap().Exec(sql, params...)
-	if err != nil {
-		return 0, err
+		keys = append(keys, item)
 	}
-
-	row, err := result.RowsAffected()
-	return int(row), err
+	return this.update(fields, And(In("id", id), this.deletedCond()), keys...)
 }
 
-// DeleteById DeleteById.
-func (this *CommonModel) DeleteById(id ...string) (int, error) {
-	sql := fmt.Sprintf("update %v set isDeleted=%v where `id` in (%v) and `isDeleted`=0", this.GetModel().GetTable(), 1, strings.Join(id, ","))
-	result, err := this.DbMap().Exec(sql)
-	if err != nil {
-		return 0, err
+// DeleteById 按id软删除，将isDeleted置为1并记录deletedTime，未启用软删除时返回ErrSoftDeleteDisabled，物理删除使用HardDeleteById.
+func (this *CommonModel) DeleteById(id ...int) (int, error) {
+	if !this.softDelete {
+		return 0, ErrSoftDeleteDisabled
 	}
-
-	row, err := result.RowsAffected()
-	return int(row), err
+	fields := map[string]interface{}{"isDeleted": 1}
+	if this.hasDeletedTime {
+		fields["deletedTime"] = time.Now()
+	}
+	return this.updateRows(AuditDelete, fields, And(In("id", id), Eq("isDeleted", 0)))
 }
 
-// GetInterfaceById 通过id获取记录.
+// GetInterfaceById 通过id获取记录，SetCache启用缓存后优先从缓存获取.
 func (this *CommonModel) GetInterfaceById(dataType interface{}, id ...int) ([]interface{}, error) {
 	if len(id) == 0 {
 		return nil, nil
 	}
-	idsStr := ""
-	for _, i := range id {
-		if idsStr != "" {
-			idsStr += ","
-		}
-		idsStr += strconv.Itoa(i)
+	if this.cacheable(dataType) {
+		return this.getCachedById(dataType, id)
 	}
-	sql := fmt.Sprintf("select %s from %s where `id` in (%v) and `isDeleted`=0", this.GetFields(), this.GetModel().GetTable(), idsStr)
-	data, err := this.DbMap().Select(dataType, sql)
+	data, err := this.SelectQuery(dataType, this.Query().Where(In("id", id)))
 
 	if err != nil {
 		return nil, err
@@ -190,17 +326,16 @@ func (this *CommonModel) SearchInterface(page int, pageSize int, sort string, qu
 	} else {
 		offset = (page - 1) * pageSize
 	}
-	orderBy := this.ParseOrder(sort)
 
-	whereStr, params := GenWhere(genCondition(query))
-	pageParams := append(params, offset, pageSize)
-	sql := fmt.Sprintf("select %v from %v where `isDeleted` = 0 and %v order by %v limit ?,?;", this.GetFields(), this.GetModel().GetTable(), whereStr, orderBy)
-	list, err := this.DbMap().Select(dataType, sql, pageParams...)
+	q := this.Query().OrderBy(strings.Split(this.ParseOrder(sort), ",")...)
+	if genCondition != nil {
+		q.Where(genCondition(query).Cond())
+	}
+	list, err := this.SelectQuery(dataType, q.Clone().Limit(pageSize).Offset(offset))
 	if err != nil {
 		return nil, total, err
 	}
-	sql = fmt.Sprintf("select count(id) from %s where `isDeleted`=0 and %v", this.GetModel().GetTable(), whereStr)
-	err = this.DbMap().SelectOne(&total, sql, params...)
+	total, err = this.CountQuery(q)
 	if err != nil {
 		return nil, total, err
 	}
@@ -208,14 +343,11 @@ func (this *CommonModel) SearchInterface(page int, pageSize int, sort string, qu
 }
 
 func (this *CommonModel) SearchAllInterface(sort string, query map[string]string, genCondition func(map[string]string) QueryMap, dataType interface{}) ([]interface{}, error) {
-	var whereStr = " 1 "
-	var params = []interface{}{}
+	q := this.Query().OrderBy(strings.Split(this.ParseOrder(sort), ",")...)
 	if genCondition != nil {
-		whereStr, params = GenWhere(genCondition(query))
+		q.Where(genCondition(query).Cond())
 	}
-	orderBy := this.ParseOrder(sort)
-	sql := fmt.Sprintf("select %v from %v where `isDeleted` = 0 and %v order by %v;", this.GetFields(), this.GetModel().GetTable(), whereStr, orderBy)
-	list, err := this.DbMap().Select(dataType, sql, params...)
+	list, err := this.SelectQuery(dataType, q)
 	if err != nil {
 		return nil, err
 	}
@@ -225,23 +357,79 @@ func (this *CommonModel) SearchAllInterface(sort string, query map[string]string
 
 // UpdateByCondition UpdateByCondition.
 func (this *CommonModel) UpdateByCondition(fields map[string]interface{}, conditions QueryMap) (int, error) {
+	return this.update(fields, And(conditions.Cond(), this.deletedCond()))
+}
+
+// update 更新满足条件的记录，未指定updatedTime时设置为当前时间.
+// 嵌入VersionModel且fields中有version时，version作为期望的版本号，没有记录被更新时返回VersionConflictError.
+func (this *CommonModel) update(fields map[string]interface{}, cond Cond, keys ...interface{}) (int, error) {
 	if _, ok := fields["updatedTime"]; !ok {
 		fields["updatedTime"] = time.Now()
 	}
-	update := ""
-	params := []interface{}{}
-	for field, value := range fields {
-		coma := ""
-		if update != "" {
-			coma = ","
+	version, checkVersion := fields["version"]
+	checkVersion = checkVersion && this.versioned
+	if checkVersion {
+		cond = And(cond, Eq("version", version))
+		fields = withoutField(fields, "version")
+	}
+	rows, err := this.updateRows(AuditUpdate, fields, cond)
+	if err != nil || rows > 0 || !checkVersion {
+		return rows, err
+	}
+	return 0, this.versionConflict(version, keys...)
+}
+
+// updateRows 更新满足条件的记录，启用审计时记录修改前后的值，启用缓存时失效被更新的记录.
+func (this *CommonModel) updateRows(action string, fields map[string]interface{}, cond Cond) (int, error) {
+	if err := CheckCond(cond); err != nil {
+		return 0, err
+	}
+	return this.auditTx(func(c *CommonModel) (int, error) {
+		before, err := c.auditRows(cond)
+		if err != nil {
+			return 0, err
+		}
+		ids, err := c.cacheIds(cond)
+		if err != nil {
+			return 0, err
+		}
+		sql, params := c.updateSql(fields, cond)
+		rows, err := c.exec(sql, params...)
+		if err != nil || rows == 0 {
+			return rows, err
+		}
+		c.InvalidateCache(ids...)
+		return rows, c.auditChanges(action, before, func(int) map[string]interface{} { return fields })
+	})
+}
+
+// updateSql 生成update语句，字段按名称排序.
+func (this *CommonModel) updateSql(fields map[string]interface{}, cond Cond) (string, []interface{}) {
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	b := newSqlBuilder(this.Dialect())
+	b.write("update ", quoteTable(b.dialect, this.GetModel().GetTable()), " set ")
+	for i, field := range names {
+		if i > 0 {
+			b.write(",")
 		}
-		params = append(params, value)
-		update += fmt.Sprintf("%v `%v`=?", coma, field)
+		b.write(b.quote(field), "=")
+		b.value(fields[field])
+	}
+	if this.versioned {
+		b.write(",", b.quote("version"), "=", b.quote("version"), "+1")
 	}
-	whereStr, ps := GenWhere(conditions)
-	params = append(params, ps...)
-	sql := fmt.Sprintf("update %v set %v where %v and `isDeleted`=0", this.GetModel().GetTable(), update, whereStr)
-	result, err := this.DbMap().Exec(sql, params...)
+	b.write(" where ")
+	cond.build(b)
+	return b.String(), b.args
+}
+
+// exec 执行语句，返回影响的行数.
+func (this *CommonModel) exec(sql string, params ...interface{}) (int, error) {
+	result, err := this.executor().Exec(sql, params...)
 	if err != nil {
 		return 0, err
 	}
@@ -250,30 +438,88 @@ func (this *CommonModel) UpdateByCondition(fields map[string]interface{}, condit
 	return int(row), err
 }
 
-// ParseOrder 格式化排序.
+// GenWhere 按当前model的Dialect组装where语句.
+func (this *CommonModel) GenWhere(whereMap QueryMap) (string, []interface{}) {
+	return GenWhere(whereMap, this.Dialect())
+}
+
+// ParseOrder 格式化排序，只保留GetFields中的字段.
 func (this *CommonModel) ParseOrder(sort string) string {
-	sorts := strings.Split(sort, ",")
+	dialect := this.Dialect()
 	res := ""
-	for _, s := range sorts {
-		order := strings.Split(strings.Trim(s, " "), " ")
-		if len(order) > 0 && strings.Contains(this.GetFields(), order[0]) {
-			if res != "" {
-				res += ","
-			}
-			res += order[0]
-			if len(order) > 1 && strings.ToLower(order[1]) == "desc" {
-				res += " desc"
-			}
+	for _, order := range this.orderFields(sort) {
+		if res != "" {
+			res += ","
+		}
+		res += quoteIdent(dialect, order.field)
+		if order.desc {
+			res += " desc"
 		}
 	}
 
 	if len(res) == 0 {
-		res = "id desc"
+		res = quoteIdent(dialect, "id") + " desc"
 	}
 
 	return res
 }
 
+// orderField 排序字段
+type orderField struct {
+	field string
+	desc  bool
+}
+
+// orderFields 解析排序，只保留GetFields中的字段.
+func (this *CommonModel) orderFields(sort string) []orderField {
+	dialect := this.Dialect()
+	fields := this.GetFields()
+	orders := make([]orderField, 0)
+	for _, s := range strings.Split(sort, ",") {
+		order := strings.Split(strings.Trim(s, " "), " ")
+		if len(order) > 0 && len(order[0]) > 0 && (strings.Contains(fields, dialect.Quote(order[0])) || strings.Contains(fields, order[0])) {
+			orders = append(orders, orderField{field: order[0], desc: len(order) > 1 && strings.ToLower(order[1]) == "desc"})
+		}
+	}
+	return orders
+}
+
+// Dialect 当前model所在数据库的Dialect.
+func (this *CommonModel) Dialect() Dialect {
+	if this.DbMap() == nil {
+		return MysqlDialect
+	}
+	return DialectOf(this.DbMap().Dialect)
+}
+
+// Query 生成查询当前表的语句，启用软删除时按WithDeleted、OnlyDeleted的范围过滤，默认只查询未删除的记录.
+func (this *CommonModel) Query() *SelectBuilder {
+	q := Select(this.GetFields()).From(this.GetModel().GetTable())
+	if cond := this.deletedCond(); cond != nil {
+		q.Where(cond)
+	}
+	return q
+}
+
+// SelectQuery 执行查询，dataType与gorp的Select一致.
+func (this *CommonModel) SelectQuery(dataType interface{}, query *SelectBuilder) ([]interface{}, error) {
+	if err := CheckCond(query); err != nil {
+		return nil, err
+	}
+	sql, params := query.ToSql(this.Dialect())
+	return this.reader().Select(dataType, sql, params...)
+}
+
+// CountQuery 统计查询的总数，忽略排序与分页.
+func (this *CommonModel) CountQuery(query *SelectBuilder) (int, error) {
+	if err := CheckCond(query); err != nil {
+		return 0, err
+	}
+	sql, params := query.Count().ToSql(this.Dialect())
+	total, err := this.reader().SelectInt(sql, params...)
+	return int(total), err
+}
+
 // PreInsert 插入前操作.
 func (this *BaseModel) PreInsert(s gorp.SqlExecutor) error {
 	this.IsDeleted = 0
@@ -292,6 +538,24 @@ func (this *BaseModel) PreUpdate(s gorp.SqlExecutor) error {
 	return nil
 }
 
+// PreInsert 插入前操作.
+func (this *VersionModel) PreInsert(s gorp.SqlExecutor) error {
+	this.IsDeleted = 0
+	if this.CreatedTime.IsZero() {
+		this.CreatedTime = time.Now()
+	}
+	if this.UpdatedTime.IsZero() {
+		this.UpdatedTime = time.Now()
+	}
+	return nil
+}
+
+// PreUpdate 更新前操作.
+func (this *VersionModel) PreUpdate(s gorp.SqlExecutor) error {
+	this.UpdatedTime = time.Now()
+	return nil
+}
+
 // PreInsert 插入前操作.
 func (this *LogModel) PreInsert(s gorp.SqlExecutor) error {
 	if this.CreatedTime.IsZero() {
@@ -337,6 +601,7 @@ func (this *SimpleModel) PostDelete(s gorp.SqlExecutor) error {
 
 // GenFieldsStrWithTable 生成携带表明的字段sql.
 func (this *CommonModel) GenFieldsStrWithTable(asName string, withoutAs ...bool) string {
+	dialect := this.Dialect()
 	fields := strings.Split(this.GetFields(), ",")
 	fieldsStr := ""
 	tableName := this.GetModel().GetTable()
@@ -348,7 +613,7 @@ func (this *CommonModel) GenFieldsStrWithTable(asName string, withoutAs ...bool)
 		isWithoutAs = true
 	}
 	for _, field := range fields {
-		fieldsStr += "`" + tableName + "`." + field
+		fieldsStr += dialect.Quote(tableName) + "." + field
 		if !isWithoutAs {
 			fieldsStr += " as " + field
 		}
@@ -357,8 +622,12 @@ func (this *CommonModel) GenFieldsStrWithTable(asName string, withoutAs ...bool)
 	return strings.Trim(fieldsStr, ",")
 }
 
-// GetAllFieldsAsString 获取model中的所有字段，防止select * 返回model中未定义的字段.
-func GetAllFieldsAsString(obj interface{}) string {
+// GetAllFieldsAsString 获取model中的所有字段，防止select * 返回model中未定义的字段，dialect默认为MysqlDialect.
+func GetAllFieldsAsString(obj interface{}, dialect ...Dialect) string {
+	var d Dialect = MysqlDialect
+	if len(dialect) > 0 && dialect[0] != nil {
+		d = dialect[0]
+	}
 	objT := reflect.TypeOf(obj)
 	var fields []string
 	for i := 0; i < objT.NumField(); i++ {
@@ -378,85 +647,147 @@ func GetAllFieldsAsString(obj interface{}) string {
 				if tag == "" || tag == "-" {
 					continue
 				}
-				fields = append(fields, "`"+tag+"`")
+				fields = append(fields, d.Quote(tag))
 			}
 			continue
 		}
 		if tag == "" {
 			continue
 		}
-		fields = append(fields, "`"+tag+"`")
+		fields = append(fields, d.Quote(tag))
 	}
 	return strings.Join(fields, ",")
 }
 
-// GenWhere 组装where语句
+// GenWhere 组装where语句，基于Cond生成，条件按key排序
+// args可传入连接符（" and "、" or "）与Dialect，默认为" and "与MysqlDialect
+// key以$or、$and、$not开头时值为嵌套的QueryMap，如$or、$or2
 // e.g.
-// 		QueryMap{
-//			"$or":QueryMap{
-//				"test4": QueryItem{"=", 1},
-//				"test6": QueryItem{"in", QueryItem{3, 5}},
-//			},
-//			"test":  QueryItem{"=", 1},
-//			"test":  QueryItem{"like", 1},
-//			"test2": QueryItem{"in", QueryItem{1, 2, 3}},
-//			"test3": QueryItem{"between", 3, 4},
-//		}
+//
+//	QueryMap{
+//		"$or":QueryMap{
+//			"test4": QueryItem{"=", 1},
+//			"test6": QueryItem{"in", QueryItem{3, 5}},
+//		},
+//		"test":  QueryItem{"like", 1},
+//		"test2": QueryItem{"in", QueryItem{1, 2, 3}},
+//		"test3": QueryItem{"between", 3, 4},
+//		"test5": QueryItem{"notNull"},
+//		"test7": QueryItem{"sql", "`test7` > `test8` + ?", 1},
+//	}
+//
+// sql的语句需为常量，不能拼接外部输入，值通过?占位传入且数量需与?一致.
+// 有误的条件（如不支持的运算符）生成为1=0，可通过CheckCond(whereMap.Cond())检查.
 func GenWhere(whereMap QueryMap, args ...interface{}) (string, []interface{}) {
+	if len(whereMap) == 0 {
+		return " 1=1 ", []interface{}{}
+	}
 	connector := " and "
-	if len(args) > 0 {
-		connector = args[0].(string)
-	}
-	where := ""
-	params := []interface{}{}
-	for key, value := range whereMap {
-		if where != "" {
-			where += connector
-		}
-		if key == "$or" {
-			w, p := GenWhere(value.(QueryMap), " or ")
-			where += "(" + w + ")"
-			params = append(params, p...)
-			continue
+	var dialect Dialect = MysqlDialect
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			connector = v
+		case Dialect:
+			dialect = v
 		}
+	}
+	var cond Cond
+	if strings.TrimSpace(strings.ToLower(connector)) == "or" {
+		cond = Or(whereMap.Conds()...)
+	} else {
+		cond = And(whereMap.Conds()...)
+	}
+	return BuildCond(dialect, cond)
+}
 
-		v := value.(QueryItem)
-		op := v[0].(string)
-		switch v[0] {
-		case "in":
-			vals := v[1].(QueryItem)
-			if vals == nil || len(vals) == 0 {
-				continue
-			}
-			valsStr := ""
-			for _, vv := range vals {
-				valsStr += ",?"
-				params = append(params, vv)
-			}
-			where += fmt.Sprintf("`%v` %v (%v)", key, op, valsStr[1:])
-		case "between":
-			where += fmt.Sprintf("`%v` %v ? and ?", key, op)
-			params = append(params, v[1], v[2])
-		case "like":
-			where += fmt.Sprintf("`%v` %v ?", key, op)
-			params = append(params, fmt.Sprintf("%%%v%%", v[1]))
-		case "notLike":
-			where += fmt.Sprintf("`%v` not like ?", key)
-			params = append(params, fmt.Sprintf("%%%v%%", v[1]))
-		case "isNull":
-			where += fmt.Sprintf("`%v` is null", key)
-		case "notNull":
-			where += fmt.Sprintf("`%v` not null", key)
-		case "sql":
-			where += fmt.Sprint(v[1])
-		default:
-			where += fmt.Sprintf("`%v` %v ?", key, op)
-			params = append(params, v[1])
+// Cond 转换为Cond，多个条件以and连接
+func (this QueryMap) Cond() Cond {
+	return And(this.Conds()...)
+}
+
+// Conds 按key排序转换为Cond列表
+func (this QueryMap) Conds() []Cond {
+	keys := make([]string, 0, len(this))
+	for key := range this {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	conds := make([]Cond, 0, len(keys))
+	for _, key := range keys {
+		conds = append(conds, queryItemCond(key, this[key]))
+	}
+	return conds
+}
+
+// queryItemLens QueryItem包含运算符在内的最少元素个数，未列出的为2
+var queryItemLens = map[string]int{"isNull": 1, "notNull": 1, "isNotNull": 1, "between": 3}
+
+func queryItemCond(key string, value interface{}) Cond {
+	if strings.HasPrefix(key, "$") {
+		sub, ok := value.(QueryMap)
+		if !ok {
+			return &errCond{err: fmt.Errorf("%v requires a QueryMap, got %T", key, value)}
 		}
+		switch {
+		case strings.HasPrefix(key, "$or"):
+			return Or(sub.Conds()...)
+		case strings.HasPrefix(key, "$and"):
+			return sub.Cond()
+		case strings.HasPrefix(key, "$not"):
+			return Not(sub.Cond())
+		}
+		return &errCond{err: fmt.Errorf("unsupported key %q", key)}
 	}
 
-	if where == "" {
-		return " 1 ", params
+	v, ok := value.(QueryItem)
+	if !ok || len(v) == 0 {
+		return &errCond{err: fmt.Errorf("invalid QueryItem for %v: %v", key, value)}
+	}
+	op, _ := v[0].(string)
+	argc, ok := queryItemLens[op]
+	if !ok {
+		argc = 2
+	}
+	if len(v) < argc {
+		return &errCond{err: fmt.Errorf("QueryItem %v for %v requires %d values", op, key, argc-1)}
+	}
+	switch op {
+	case "in", "notIn":
+		var values []interface{}
+		if v[1] != nil {
+			values = []interface{}{v[1]}
+		}
+		if op == "in" {
+			return In(key, values...)
+		}
+		return NotIn(key, values...)
+	case "between":
+		return Between(key, v[1], v[2])
+	case "like":
+		return Like(key, fmt.Sprintf("%%%v%%", v[1]))
+	case "notLike":
+		return NotLike(key, fmt.Sprintf("%%%v%%", v[1]))
+	case "isNull":
+		return IsNull(key)
+	case "notNull", "isNotNull":
+		return IsNotNull(key)
+	case "exists", "notExists":
+		sub, ok := v[1].(*SelectBuilder)
+		if !ok {
+			return &errCond{err: fmt.Errorf("QueryItem %v for %v requires a *SelectBuilder", op, key)}
+		}
+		if op == "exists" {
+			return Exists(sub)
+		}
+		return NotExists(sub)
+	case "sql":
+		// sql需为常量，值只能通过?占位传入，占位符数量需与参数一致
+		sql, ok := v[1].(string)
+		if !ok || strings.Count(sql, "?") != len(v)-2 {
+			return &errCond{err: fmt.Errorf("QueryItem sql for %v requires a string with one ? per argument", key)}
+		}
+		return Expr(sql, v[2:]...)
 	}
-	return where, params
+	return Compare(key, op, v[1])
 }
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenWhereEmpty(t *testing.T) {
+	for _, dialect := range []Dialect{MysqlDialect, SqliteDialect, PostgresDialect} {
+		where, params := GenWhere(QueryMap{}, dialect)
+		if where != " 1=1 " || len(params) != 0 {
+			t.Errorf("%T: got %q %v", dialect, where, params)
+		}
+		where, params = GenWhere(nil, " or ", dialect)
+		if where != " 1=1 " || len(params) != 0 {
+			t.Errorf("%T or: got %q %v", dialect, where, params)
+		}
+	}
+}
+
+func TestGenWherePostgres(t *testing.T) {
+	where, params := GenWhere(QueryMap{
+		"name":   QueryItem{"=", "a"},
+		"status": QueryItem{"in", QueryItem{1, 2}},
+	}, PostgresDialect)
+	if want := `"name" = $1 and "status" in ($2,$3)`; where != want {
+		t.Errorf("got %q, want %q", where, want)
+	}
+	if want := []interface{}{"a", 1, 2}; !reflect.DeepEqual(params, want) {
+		t.Errorf("got %v, want %v", params, want)
+	}
+}
+
+func TestGenWhereInvalidItems(t *testing.T) {
+	for _, whereMap := range []QueryMap{
+		{"a": QueryItem{"drop", 1}},
+		{"a": QueryItem{"between", 1}},
+		{"a": QueryItem{"sql", "`a` > `b` + ?"}},
+		{"a": QueryItem{"sql", "`a` > 1"}, "b": QueryItem{"=", 1}, "$or": QueryItem{"=", 1}},
+	} {
+		sql, _ := GenWhere(whereMap)
+		if err := CheckCond(whereMap.Cond()); err == nil || !strings.Contains(sql, "1=0") {
+			t.Fatalf("%v: %q %v", whereMap, sql, err)
+		}
+	}
+	whereMap := QueryMap{"a": QueryItem{"sql", "`a` > `b` + ?", 1}}
+	sql, args := GenWhere(whereMap)
+	if err := CheckCond(whereMap.Cond()); err != nil || sql != "`a` > `b` + ?" || len(args) != 1 {
+		t.Fatalf("%q %v %v", sql, args, err)
+	}
+}
@@ -0,0 +1,534 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gorp "gopkg.in/gorp.v1"
+)
+
+const defaultBatchSize = 500
+
+// batchRows 批量写入的结构体与对应的列
+type batchRows struct {
+	table    string
+	elemType reflect.Type
+	columns  []string
+	indexes  [][]int
+	idIndex  []int
+	// 嵌入VersionModel时为Version的位置，否则为nil
+	versionIndex []int
+	// 所有记录都已设置id时插入id列，部分设置时返回错误
+	withId bool
+	// 多行插入的id不保证连续时逐行插入
+	rowByRow bool
+	objs     []interface{}
+	values   []reflect.Value
+}
+
+// CreateBatch 批量插入，models为[]*T，chunkSize为每条语句插入的行数，默认500，超过数据库参数上限时自动减小.
+// 所有批次在同一事务中执行，插入前后调用PreInsert、PostInsert，插入后回填Id，嵌入VersionModel时Version为1，返回与models顺序一致的id.
+// models需全部设置id或全部不设置id，部分设置时返回错误.
+// postgres通过returning获取id，sqlite与mysql由LastInsertId推算；mysql的innodb_autoinc_lock_mode为2或auto_increment_increment不为1时
+// 多行插入的id不连续，此时逐行插入，该配置每个DbMap只查询一次.
+func (this *CommonModel) CreateBatch(models interface{}, chunkSize int) ([]int, error) {
+	rows, err := this.newBatchRows(models)
+	if err != nil || len(rows.values) == 0 {
+		return nil, err
+	}
+	dialect := this.Dialect()
+	size := batchSize(dialect, chunkSize, len(rows.columns))
+	err = this.Transaction(func(tx *Tx) error {
+		m := tx.Model(this.GetModel()).(commonGetter).common()
+		if err := rows.preInsert(tx.Transaction); err != nil {
+			return err
+		}
+		if dialect.Name() == "mysql" && !rows.withId {
+			rows.rowByRow = !consecutiveAutoInc(this.DbMap(), m.executor())
+		}
+		for start := 0; start < len(rows.values); start += size {
+			end := start + size
+			if end > len(rows.values) {
+				end = len(rows.values)
+			}
+			if err := rows.insert(m.executor(), dialect, rows.values[start:end]); err != nil {
+				return err
+			}
+		}
+		if err := rows.postInsert(tx.Transaction); err != nil {
+			return err
+		}
+		m.InvalidateCache(rows.ids()...)
+		return m.auditCreated(AuditCreate, rows.objs...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows.ids(), nil
+}
+
+// Upsert 批量插入，conflictKeys对应的唯一索引冲突时更新updateFields，updateFields为空时更新除id、createdTime与conflictKeys外的所有字段.
+// mysql使用on duplicate key update，sqlite与postgres使用on conflict，同一批次中conflictKeys的值不能重复.
+// 嵌入VersionModel时冲突的记录版本号加1，执行后按conflictKeys查询id与版本号并回填，返回与models顺序一致的id.
+// models需全部设置id或全部不设置id，部分设置时返回错误.
+func (this *CommonModel) Upsert(models interface{}, conflictKeys []string, updateFields []string, chunkSize int) ([]int, error) {
+	if len(conflictKeys) == 0 {
+		return nil, errors.New("conflictKeys must not be empty")
+	}
+	rows, err := this.newBatchRows(models)
+	if err != nil || len(rows.values) == 0 {
+		return nil, err
+	}
+	keyIndexes := make([][]int, 0, len(conflictKeys))
+	for _, key := range conflictKeys {
+		index, ok := columnIndex(rows.elemType, key)
+		if !ok {
+			return nil, fmt.Errorf("field %v not found in %v", key, rows.elemType)
+		}
+		keyIndexes = append(keyIndexes, index)
+	}
+	if len(updateFields) == 0 {
+		updateFields = rows.updateColumns(conflictKeys)
+	}
+	dialect := this.Dialect()
+	size := batchSize(dialect, chunkSize, len(rows.columns))
+	err = this.Transaction(func(tx *Tx) error {
+		m := tx.Model(this.GetModel()).(commonGetter).common()
+		if err := rows.preInsert(tx.Transaction); err != nil {
+			return err
+		}
+		for start := 0; start < len(rows.values); start += size {
+			end := start + size
+			if end > len(rows.values) {
+				end = len(rows.values)
+			}
+			chunk := rows.values[start:end]
+			b := rows.insertSql(dialect, chunk)
+			writeUpsert(b, conflictKeys, updateFields, rows.versionIndex != nil)
+			if _, err := m.executor().Exec(b.String(), b.args...); err != nil {
+				return err
+			}
+			if err := rows.fillIdsByKeys(m.executor(), dialect, chunk, conflictKeys, keyIndexes); err != nil {
+				return err
+			}
+		}
+		if err := rows.postInsert(tx.Transaction); err != nil {
+			return err
+		}
+		m.InvalidateCache(rows.ids()...)
+		return m.auditCreated(AuditUpsert, rows.objs...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows.ids(), nil
+}
+
+// UpdateBatchById 按id批量更新，rows的key为id，value为该行要更新的字段，各行的字段可以不同，未指定updatedTime时设置为当前时间.
+// 每批生成一条update ... case语句，所有批次在同一事务中执行，返回按软删除范围存在的id.
+// 嵌入VersionModel时版本号加1，不检查版本号，rows中的version会被忽略.
+func (this *CommonModel) UpdateBatchById(rows map[int]map[string]interface{}, chunkSize int) ([]int, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	ids := make([]int, 0, len(rows))
+	fieldSet := map[string]bool{"updatedTime": true}
+	for id, fields := range rows {
+		ids = append(ids, id)
+		for field := range fields {
+			fieldSet[field] = true
+		}
+	}
+	if this.versioned {
+		delete(fieldSet, "version")
+	}
+	sort.Ints(ids)
+	fields := make([]string, 0, len(fieldSet))
+	for field := range fieldSet {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	dialect := this.Dialect()
+	size := batchSize(dialect, chunkSize, len(fields)*2+1)
+	updated := make([]int, 0, len(ids))
+	err := this.Transaction(func(tx *Tx) error {
+		m := tx.Model(this.GetModel()).(commonGetter).common()
+		for start := 0; start < len(ids); start += size {
+			end := start + size
+			if end > len(ids) {
+				end = len(ids)
+			}
+			var matched []int64
+			sql, params := Select("id").From(this.GetModel().GetTable()).
+				Where(In("id", ids[start:end]), this.deletedCond()).OrderBy("id").ToSql(dialect)
+			if _, err := m.executor().Select(&matched, sql, params...); err != nil {
+				return err
+			}
+			if len(matched) == 0 {
+				continue
+			}
+			before, err := m.auditRows(In("id", matched))
+			if err != nil {
+				return err
+			}
+			b := newSqlBuilder(dialect)
+			b.write("update ", quoteTable(dialect, this.GetModel().GetTable()), " set ")
+			for i, field := range fields {
+				if i > 0 {
+					b.write(",")
+				}
+				// else保留原值，同时让postgres按字段类型推断参数类型
+				b.write(b.quote(field), "=case")
+				for _, id := range matched {
+					value, ok := rows[int(id)][field]
+					if !ok && field != "updatedTime" {
+						continue
+					}
+					if !ok {
+						value = now
+					}
+					b.write(" when ", b.quote("id"), "=")
+					b.value(id)
+					b.write(" then ")
+					b.value(value)
+				}
+				b.write(" else ", b.quote(field), " end")
+			}
+			if this.versioned {
+				b.write(",", b.quote("version"), "=", b.quote("version"), "+1")
+			}
+			b.write(" where ")
+			In("id", matched).build(b)
+			if _, err := m.executor().Exec(b.String(), b.args...); err != nil {
+				return err
+			}
+			err = m.auditChanges(AuditUpdate, before, func(id int) map[string]interface{} {
+				changed := make(map[string]interface{}, len(rows[id])+1)
+				for field, value := range rows[id] {
+					if fieldSet[field] {
+						changed[field] = value
+					}
+				}
+				if _, ok := changed["updatedTime"]; !ok {
+					changed["updatedTime"] = now
+				}
+				return changed
+			})
+			if err != nil {
+				return err
+			}
+			chunk := make([]int, 0, len(matched))
+			for _, id := range matched {
+				chunk = append(chunk, int(id))
+			}
+			m.InvalidateCache(chunk...)
+			updated = append(updated, chunk...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// newBatchRows 解析models，列与gorp的表映射一致
+func (this *CommonModel) newBatchRows(models interface{}) (*batchRows, error) {
+	v := reflect.ValueOf(models)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Ptr || v.Type().Elem().Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("models must be a slice of struct pointers, got %T", models)
+	}
+	elemType := v.Type().Elem().Elem()
+	table, err := this.DbMap().TableFor(elemType, false)
+	if err != nil {
+		return nil, err
+	}
+	idIndex, ok := columnIndex(elemType, "id")
+	if !ok {
+		return nil, fmt.Errorf("field id not found in %v", elemType)
+	}
+	rows := &batchRows{table: this.GetModel().GetTable(), elemType: elemType, idIndex: idIndex, withId: v.Len() > 0}
+	if this.versioned {
+		if rows.versionIndex, ok = columnIndex(elemType, "version"); !ok {
+			return nil, fmt.Errorf("field version not found in %v", elemType)
+		}
+	}
+	withoutId := 0
+	for i := 0; i < v.Len(); i++ {
+		obj := v.Index(i)
+		if obj.IsNil() {
+			return nil, fmt.Errorf("models[%d] is nil", i)
+		}
+		rows.objs = append(rows.objs, obj.Interface())
+		rows.values = append(rows.values, obj.Elem())
+		if obj.Elem().FieldByIndex(idIndex).Int() == 0 {
+			withoutId++
+		}
+	}
+	if withoutId > 0 && withoutId < v.Len() {
+		return nil, fmt.Errorf("%d of %d models have no id, ids must be set for all or none", withoutId, v.Len())
+	}
+	rows.withId = withoutId == 0
+	for _, column := range table.Columns {
+		if column.Transient || (!rows.withId && strings.EqualFold(column.ColumnName, "id")) {
+			continue
+		}
+		index, ok := columnIndex(elemType, column.ColumnName)
+		if !ok {
+			return nil, fmt.Errorf("field %v not found in %v", column.ColumnName, elemType)
+		}
+		rows.columns = append(rows.columns, column.ColumnName)
+		rows.indexes = append(rows.indexes, index)
+	}
+	return rows, nil
+}
+
+func (this *batchRows) preInsert(exec gorp.SqlExecutor) error {
+	for _, obj := range this.objs {
+		if hook, ok := obj.(gorp.HasPreInsert); ok {
+			if err := hook.PreInsert(exec); err != nil {
+				return err
+			}
+		}
+	}
+	if this.versionIndex != nil {
+		for _, value := range this.values {
+			value.FieldByIndex(this.versionIndex).SetInt(1)
+		}
+	}
+	return nil
+}
+
+func (this *batchRows) postInsert(exec gorp.SqlExecutor) error {
+	for _, obj := range this.objs {
+		if hook, ok := obj.(gorp.HasPostInsert); ok {
+			if err := hook.PostInsert(exec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// insertSql 生成多行insert语句
+func (this *batchRows) insertSql(dialect Dialect, values []reflect.Value) *sqlBuilder {
+	b := newSqlBuilder(dialect)
+	b.write("insert into ", quoteTable(dialect, this.table), " (")
+	for i, column := range this.columns {
+		if i > 0 {
+			b.write(",")
+		}
+		b.write(b.quote(column))
+	}
+	b.write(") values ")
+	for i, value := range values {
+		if i > 0 {
+			b.write(",")
+		}
+		b.write("(")
+		for j, index := range this.indexes {
+			if j > 0 {
+				b.write(",")
+			}
+			b.value(value.FieldByIndex(index).Interface())
+		}
+		b.write(")")
+	}
+	return b
+}
+
+// insert 插入一批记录并回填id，postgres使用returning，mysql与sqlite由LastInsertId推算
+func (this *batchRows) insert(exec sqlRunner, dialect Dialect, values []reflect.Value) error {
+	if this.rowByRow && len(values) > 1 {
+		for i := range values {
+			if err := this.insert(exec, dialect, values[i:i+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	b := this.insertSql(dialect, values)
+	if this.withId {
+		_, err := exec.Exec(b.String(), b.args...)
+		return err
+	}
+	if dialect.Name() == "postgres" {
+		b.write(" returning ", b.quote("id"))
+		var ids []int64
+		if _, err := exec.Select(&ids, b.String(), b.args...); err != nil {
+			return err
+		}
+		if len(ids) != len(values) {
+			return fmt.Errorf("expected %d ids, got %d", len(values), len(ids))
+		}
+		for i, value := range values {
+			value.FieldByIndex(this.idIndex).SetInt(ids[i])
+		}
+		return nil
+	}
+	result, err := exec.Exec(b.String(), b.args...)
+	if err != nil {
+		return err
+	}
+	lastId, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	// mysql返回本批第一条的id，sqlite返回最后一条的id
+	firstId := lastId
+	if dialect.Name() == "sqlite" {
+		firstId = lastId - int64(len(values)) + 1
+	}
+	for i, value := range values {
+		value.FieldByIndex(this.idIndex).SetInt(firstId + int64(i))
+	}
+	return nil
+}
+
+// autoIncModes consecutiveAutoInc的结果，key为*gorp.DbMap
+var autoIncModes sync.Map
+
+// consecutiveAutoInc mysql多行插入分配的自增id是否连续，每个dbMap只查询一次，
+// innodb_autoinc_lock_mode为2时并发插入的id会交错，auto_increment_increment不为1时id有间隔，查询失败时按不连续处理且不缓存
+func consecutiveAutoInc(dbMap *gorp.DbMap, exec sqlRunner) bool {
+	if consecutive, ok := autoIncModes.Load(dbMap); ok {
+		return consecutive.(bool)
+	}
+	lockMode, err := exec.SelectInt("select @@innodb_autoinc_lock_mode")
+	if err != nil {
+		return false
+	}
+	increment, err := exec.SelectInt("select @@auto_increment_increment")
+	if err != nil {
+		return false
+	}
+	consecutive := lockMode != 2 && increment == 1
+	autoIncModes.Store(dbMap, consecutive)
+	return consecutive
+}
+
+// updateColumns upsert默认更新的列
+func (this *batchRows) updateColumns(conflictKeys []string) []string {
+	columns := make([]string, 0, len(this.columns))
+	for _, column := range this.columns {
+		skip := strings.EqualFold(column, "id") || strings.EqualFold(column, "createdTime") || strings.EqualFold(column, "version")
+		for _, key := range conflictKeys {
+			skip = skip || strings.EqualFold(column, key)
+		}
+		if !skip {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// writeUpsert 写入冲突时的更新语句，versioned为true时版本号加1
+func writeUpsert(b *sqlBuilder, conflictKeys []string, updateFields []string, versioned bool) {
+	sets := make([]string, 0, len(updateFields)+1)
+	for _, field := range updateFields {
+		if b.dialect.Name() == "mysql" {
+			sets = append(sets, b.quote(field)+"=values("+b.quote(field)+")")
+		} else {
+			sets = append(sets, b.quote(field)+"=excluded."+b.quote(field))
+		}
+	}
+	if versioned {
+		sets = append(sets, b.quote("version")+"="+b.quote("version")+"+1")
+	}
+	if b.dialect.Name() == "mysql" {
+		b.write(" on duplicate key update ")
+		if len(sets) == 0 {
+			b.write(b.quote("id"), "=", b.quote("id"))
+		}
+		b.write(strings.Join(sets, ","))
+		return
+	}
+	quotedKeys := make([]string, 0, len(conflictKeys))
+	for _, key := range conflictKeys {
+		quotedKeys = append(quotedKeys, b.quote(key))
+	}
+	b.write(" on conflict (", strings.Join(quotedKeys, ","), ")")
+	if len(sets) == 0 {
+		b.write(" do nothing")
+		return
+	}
+	b.write(" do update set ", strings.Join(sets, ","))
+}
+
+// fillIdsByKeys 按conflictKeys查询upsert后的id与版本号并回填
+func (this *batchRows) fillIdsByKeys(exec sqlRunner, dialect Dialect, values []reflect.Value, conflictKeys []string, keyIndexes [][]int) error {
+	conds := make([]Cond, 0, len(values))
+	for _, value := range values {
+		items := make([]Cond, 0, len(conflictKeys))
+		for i, key := range conflictKeys {
+			items = append(items, Eq(key, value.FieldByIndex(keyIndexes[i]).Interface()))
+		}
+		conds = append(conds, And(items...))
+	}
+	fields := append([]string{"id"}, conflictKeys...)
+	if this.versionIndex != nil {
+		fields = append(fields, "version")
+	}
+	sql, params := Select(fields...).From(this.table).Where(Or(conds...)).ToSql(dialect)
+	found := reflect.New(reflect.SliceOf(reflect.PtrTo(this.elemType)))
+	if _, err := exec.Select(found.Interface(), sql, params...); err != nil {
+		return err
+	}
+	rows := make(map[string]reflect.Value, found.Elem().Len())
+	for i := 0; i < found.Elem().Len(); i++ {
+		value := found.Elem().Index(i).Elem()
+		rows[keyOf(value, keyIndexes)] = value
+	}
+	for _, value := range values {
+		row, ok := rows[keyOf(value, keyIndexes)]
+		if !ok {
+			return fmt.Errorf("upserted row %v not found", keyOf(value, keyIndexes))
+		}
+		value.FieldByIndex(this.idIndex).Set(row.FieldByIndex(this.idIndex))
+		if this.versionIndex != nil {
+			value.FieldByIndex(this.versionIndex).Set(row.FieldByIndex(this.versionIndex))
+		}
+	}
+	return nil
+}
+
+func (this *batchRows) ids() []int {
+	ids := make([]int, 0, len(this.values))
+	for _, value := range this.values {
+		ids = append(ids, int(value.FieldByIndex(this.idIndex).Int()))
+	}
+	return ids
+}
+
+func keyOf(value reflect.Value, keyIndexes [][]int) string {
+	keys := make([]interface{}, 0, len(keyIndexes))
+	for _, index := range keyIndexes {
+		keys = append(keys, value.FieldByIndex(index).Interface())
+	}
+	return fmt.Sprintf("%#v", keys)
+}
+
+// batchSize 每批的行数，保证参数数量不超过数据库上限，sqlite按旧版本的999计算
+func batchSize(dialect Dialect, size int, paramsPerRow int) int {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	maxParams := 65535
+	if dialect.Name() == "sqlite" {
+		maxParams = 999
+	}
+	if paramsPerRow > 0 && size > maxParams/paramsPerRow {
+		size = maxParams / paramsPerRow
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
@@ -0,0 +1,289 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sayuri567/tool/util/cache"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCacheTTL         = time.Minute
+	defaultNegativeCacheTTL = 5 * time.Second
+	defaultInvalidateDelay  = time.Second
+)
+
+// CacheOptions 按id查询的缓存配置
+type CacheOptions struct {
+	// 缓存存储，必填，如cache.NewMemoryStore(10000)、redispool.NewCache("", "model:cache:")，值为空时表示记录不存在
+	Store cache.Store
+	// 缓存时间，默认1分钟
+	TTL time.Duration
+	// 记录不存在时的缓存时间，默认5秒，小于0时不缓存
+	NegativeTTL time.Duration
+	// 缓存key的前缀，默认为表名，多个数据库有同名表且共用Store时需设置，在SetModel之前调用SetCache时必填
+	Prefix string
+	// 失效后再次删除缓存的延迟，默认1秒，小于0时不再次删除.
+	// 多个实例共用Store时，其他实例在修改前读取、修改后才写入的旧值由再次删除清除，读取到写入超过该延迟时旧值保留到TTL过期
+	InvalidateDelay time.Duration
+}
+
+// modelCache 缓存配置与加载状态，model的副本共用
+type modelCache struct {
+	options *CacheOptions
+	// 失效次数，加载期间发生失效时不写入缓存或写入后删除，只对本实例的失效有效
+	gen   uint64
+	lock  sync.Mutex
+	calls map[string]*cacheCall
+}
+
+// cacheCall 正在进行的加载，相同key的并发请求等待同一次加载
+type cacheCall struct {
+	wg    sync.WaitGroup
+	found map[int][]byte
+	err   error
+}
+
+// SetCache 启用GetInterfaceById的缓存，options为nil时关闭.
+// 只缓存dataType为Initer绑定的结构体的查询，事务内、WithDeleted、OnlyDeleted时不使用缓存，未命中时读主库.
+// Create、UpdateById、UpdateByCondition、DeleteById、批量修改等方法修改后自动失效，事务中的修改在提交后再次失效.
+// 缓存的是PostGet之后的值，结构体通过gob编码。多个实例共用Store时的一致性限制见CacheOptions.InvalidateDelay.
+// e.g.
+//
+//	err := configModel.SetCache(&model.CacheOptions{Store: cache.NewMemoryStore(1000), TTL: 10 * time.Minute})
+func (this *CommonModel) SetCache(options *CacheOptions) error {
+	if options == nil {
+		this.cache = nil
+		return nil
+	}
+	if options.Store == nil {
+		return errors.New("cache store must be set")
+	}
+	copied := *options
+	if copied.TTL <= 0 {
+		copied.TTL = defaultCacheTTL
+	}
+	if copied.NegativeTTL == 0 {
+		copied.NegativeTTL = defaultNegativeCacheTTL
+	}
+	if copied.InvalidateDelay == 0 {
+		copied.InvalidateDelay = defaultInvalidateDelay
+	}
+	if len(copied.Prefix) == 0 {
+		if this.GetModel() == nil {
+			return errors.New("cache prefix must be set before SetModel")
+		}
+		copied.Prefix = this.GetModel().GetTable()
+	}
+	this.cache = &modelCache{options: &copied, calls: make(map[string]*cacheCall)}
+	return nil
+}
+
+// InvalidateCache 失效id对应的缓存，用于绕过CommonModel直接修改数据后.
+func (this *CommonModel) InvalidateCache(id ...int) {
+	if this.cache == nil || len(id) == 0 {
+		return
+	}
+	keys := this.cacheKeys(id)
+	this.cache.invalidate(keys)
+	if this.tx != nil {
+		c := this.cache
+		this.tx.afterCommit(func() { c.invalidate(keys) })
+	}
+}
+
+// cacheable 是否从缓存查询
+func (this *CommonModel) cacheable(dataType interface{}) bool {
+	if this.cache == nil || this.tx != nil || this.scope != scopeNotDeleted {
+		return false
+	}
+	t, err := elemTypeOf(dataType)
+	return err == nil && t == this.objType
+}
+
+// cacheIds 修改前查询满足条件的id，修改后失效缓存，未启用缓存时返回nil
+func (this *CommonModel) cacheIds(cond Cond) ([]int, error) {
+	if this.cache == nil {
+		return nil, nil
+	}
+	var ids []int64
+	sql, params := Select("id").From(this.GetModel().GetTable()).Where(cond).ToSql(this.Dialect())
+	if _, err := this.executor().Select(&ids, sql, params...); err != nil {
+		return nil, err
+	}
+	res := make([]int, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, int(id))
+	}
+	return res, nil
+}
+
+func (this *CommonModel) cacheKeys(ids []int) []string {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, this.cache.options.Prefix+":"+strconv.Itoa(id))
+	}
+	return keys
+}
+
+// getCachedById 按id从缓存查询，未命中的id合并为一次查询，结果按ids的顺序返回
+func (this *CommonModel) getCachedById(dataType interface{}, ids []int) ([]interface{}, error) {
+	ids = uniqueIds(ids)
+	keys := this.cacheKeys(ids)
+	found := make(map[int][]byte, len(ids))
+	missing := make([]int, 0, len(ids))
+	for i, id := range ids {
+		value, ok, err := this.cache.options.Store.Get(keys[i])
+		if err != nil {
+			logrus.WithError(err).WithField("key", keys[i]).Warn("get model cache failed")
+		}
+		if ok {
+			found[id] = value
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		loaded, err := this.cache.do(strings.Join(this.cacheKeys(missing), ","), func() (map[int][]byte, error) {
+			return this.loadCache(missing)
+		})
+		if (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) && this.Context().Err() == nil {
+			// 等待的加载因发起请求的ctx结束而失败，自行加载
+			loaded, err = this.loadCache(missing)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for id, value := range loaded {
+			found[id] = value
+		}
+	}
+
+	var list []interface{}
+	dest := reflect.Indirect(reflect.ValueOf(dataType))
+	appendToSlice := dest.Kind() == reflect.Slice
+	for _, id := range ids {
+		value := found[id]
+		if len(value) == 0 {
+			continue
+		}
+		obj := reflect.New(this.objType)
+		if err := gob.NewDecoder(bytes.NewReader(value)).Decode(obj.Interface()); err != nil {
+			return nil, err
+		}
+		if !appendToSlice {
+			list = append(list, obj.Interface())
+		} else if dest.Type().Elem().Kind() == reflect.Ptr {
+			dest.Set(reflect.Append(dest, obj))
+		} else {
+			dest.Set(reflect.Append(dest, obj.Elem()))
+		}
+	}
+	if appendToSlice && dest.IsNil() {
+		dest.Set(reflect.MakeSlice(dest.Type(), 0, 0))
+	}
+	return list, nil
+}
+
+// loadCache 从主库查询并写入缓存，不存在的id写入空值，写入期间发生失效时删除写入的值
+func (this *CommonModel) loadCache(ids []int) (map[int][]byte, error) {
+	gen := atomic.LoadUint64(&this.cache.gen)
+	list := reflect.New(reflect.SliceOf(reflect.PtrTo(this.objType)))
+	sql, params := this.Query().Where(In("id", ids)).ToSql(this.Dialect())
+	if _, err := this.runner(this.DbMap()).Select(list.Interface(), sql, params...); err != nil {
+		return nil, err
+	}
+	found := make(map[int][]byte, len(ids))
+	for i := 0; i < list.Elem().Len(); i++ {
+		obj := list.Elem().Index(i)
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(obj.Interface()); err != nil {
+			return nil, fmt.Errorf("encode %v for cache: %v", this.objType, err)
+		}
+		found[int(obj.Elem().FieldByName("Id").Int())] = buf.Bytes()
+	}
+	if atomic.LoadUint64(&this.cache.gen) != gen {
+		return found, nil
+	}
+	options := this.cache.options
+	keys := this.cacheKeys(ids)
+	for i, id := range ids {
+		value, ttl := found[id], options.TTL
+		if value == nil {
+			if options.NegativeTTL < 0 {
+				continue
+			}
+			value, ttl = []byte{}, options.NegativeTTL
+		}
+		if err := options.Store.Set(keys[i], value, ttl); err != nil {
+			logrus.WithError(err).WithField("key", keys[i]).Warn("set model cache failed")
+		}
+	}
+	if atomic.LoadUint64(&this.cache.gen) != gen {
+		// 检查与写入之间发生了失效，写入的可能是旧值
+		this.cache.delete(keys)
+	}
+	return found, nil
+}
+
+// do 相同key同时只执行一次fn，其他请求等待并共用结果
+func (this *modelCache) do(key string, fn func() (map[int][]byte, error)) (map[int][]byte, error) {
+	this.lock.Lock()
+	if call, ok := this.calls[key]; ok {
+		this.lock.Unlock()
+		call.wg.Wait()
+		return call.found, call.err
+	}
+	call := &cacheCall{}
+	call.wg.Add(1)
+	this.calls[key] = call
+	this.lock.Unlock()
+
+	defer func() {
+		call.wg.Done()
+		this.lock.Lock()
+		delete(this.calls, key)
+		this.lock.Unlock()
+	}()
+	call.found, call.err = fn()
+	return call.found, call.err
+}
+
+// invalidate 删除缓存，延迟InvalidateDelay后再次删除
+func (this *modelCache) invalidate(keys []string) {
+	atomic.AddUint64(&this.gen, 1)
+	this.delete(keys)
+	if this.options.InvalidateDelay > 0 {
+		time.AfterFunc(this.options.InvalidateDelay, func() { this.delete(keys) })
+	}
+}
+
+func (this *modelCache) delete(keys []string) {
+	if err := this.options.Store.Delete(keys...); err != nil {
+		logrus.WithError(err).WithField("keys", keys).Error("delete model cache failed")
+	}
+}
+
+func uniqueIds(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	res := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	sort.Ints(res)
+	return res
+}
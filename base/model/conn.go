@@ -0,0 +1,335 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+
+	gorp "gopkg.in/gorp.v1"
+)
+
+// Connector 驱动的Connector，驱动未实现driver.DriverContext时每次按dsn打开连接.
+func Connector(driverName string, dsn string) (driver.Connector, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	d := db.Driver()
+	db.Close()
+	if dc, ok := d.(driver.DriverContext); ok {
+		return dc.OpenConnector(dsn)
+	}
+	return &dsnConnector{dsn: dsn, driver: d}, nil
+}
+
+// WrapConnector 包装连接池的连接，连接池由此打开时model的context与超时可以中断执行中的语句.
+// mysql、sqlite、postgres模块打开的连接池已经过包装.
+func WrapConnector(connector driver.Connector) driver.Connector {
+	return &contextConnector{connector: connector, driver: &contextDriver{Driver: connector.Driver()}}
+}
+
+// OpenDB 同sql.Open，连接池经过WrapConnector包装.
+func OpenDB(driverName string, dsn string) (*sql.DB, error) {
+	connector, err := Connector(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(WrapConnector(connector)), nil
+}
+
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (this *dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return this.driver.Open(this.dsn)
+}
+
+func (this *dsnConnector) Driver() driver.Driver {
+	return this.driver
+}
+
+// contextDriver 标记连接池经过WrapConnector包装，并记录启用审计的表
+type contextDriver struct {
+	driver.Driver
+	// audited 启用审计的表名（小写）
+	audited      sync.Map
+	auditedCount int32
+}
+
+type contextConnector struct {
+	connector driver.Connector
+	driver    *contextDriver
+}
+
+func (this *contextConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := this.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &contextConn{conn: conn, driver: this.driver}, nil
+}
+
+func (this *contextConnector) Driver() driver.Driver {
+	return this.driver
+}
+
+// withConn 从dbMap的连接池取出一个连接，fn中的DbMap副本只使用该连接，语句使用ctx执行.
+// gorp.v1不支持context，连接池未经WrapConnector包装时只在执行前检查ctx.
+func withConn(ctx context.Context, dbMap *gorp.DbMap, fn func(m *gorp.DbMap, conn *contextConn) error) error {
+	if _, ok := dbMap.Db.Driver().(*contextDriver); !ok {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(dbMap, nil)
+	}
+	c, err := dbMap.Db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Raw(func(driverConn interface{}) error {
+		conn := driverConn.(*contextConn)
+		return conn.use(ctx, func() error {
+			m := *dbMap
+			m.Db = conn.pinned()
+			return fn(&m, conn)
+		})
+	})
+}
+
+// contextConn 包装驱动连接，use期间以指定的ctx代替database/sql传入的ctx调用驱动
+type contextConn struct {
+	conn   driver.Conn
+	driver *contextDriver
+	lock   sync.Mutex
+	ctx    context.Context
+	// db 只使用该连接的连接池，供gorp在取出的连接上执行，首次使用时创建，随连接关闭
+	db  *sql.DB
+	bad bool
+}
+
+// use 在fn执行期间使用ctx
+func (this *contextConn) use(ctx context.Context, fn func() error) error {
+	this.lock.Lock()
+	prev := this.ctx
+	this.ctx = ctx
+	this.lock.Unlock()
+	defer func() {
+		this.lock.Lock()
+		this.ctx = prev
+		this.lock.Unlock()
+	}()
+	return fn()
+}
+
+// context 调用驱动使用的ctx
+func (this *contextConn) context(ctx context.Context) context.Context {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.ctx != nil {
+		return this.ctx
+	}
+	return ctx
+}
+
+func (this *contextConn) pinned() *sql.DB {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.db == nil {
+		this.db = sql.OpenDB(&pinnedConnector{conn: this})
+		this.db.SetMaxOpenConns(1)
+	}
+	return this.db
+}
+
+func (this *contextConn) Prepare(query string) (driver.Stmt, error) {
+	return this.PrepareContext(context.Background(), query)
+}
+
+func (this *contextConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	ctx = this.context(ctx)
+	if err := this.driver.checkWrite(ctx, query); err != nil {
+		return nil, err
+	}
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := this.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = this.conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &contextStmt{stmt: stmt, conn: this}, nil
+}
+
+func (this *contextConn) Close() error {
+	this.lock.Lock()
+	db := this.db
+	this.db = nil
+	this.lock.Unlock()
+	if db != nil {
+		db.Close()
+	}
+	return this.conn.Close()
+}
+
+func (this *contextConn) Begin() (driver.Tx, error) {
+	return this.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (this *contextConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := this.conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(this.context(ctx), opts)
+	}
+	return this.conn.Begin() //nolint:staticcheck
+}
+
+func (this *contextConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ctx = this.context(ctx)
+	if err := this.driver.checkWrite(ctx, query); err != nil {
+		return nil, err
+	}
+	if execer, ok := this.conn.(driver.ExecerContext); ok {
+		return execer.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (this *contextConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	ctx = this.context(ctx)
+	if err := this.driver.checkWrite(ctx, query); err != nil {
+		return nil, err
+	}
+	if queryer, ok := this.conn.(driver.QueryerContext); ok {
+		return queryer.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (this *contextConn) Ping(ctx context.Context) error {
+	if pinger, ok := this.conn.(driver.Pinger); ok {
+		return pinger.Ping(this.context(ctx))
+	}
+	return nil
+}
+
+func (this *contextConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := this.conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (this *contextConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := this.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (this *contextConn) IsValid() bool {
+	this.lock.Lock()
+	bad := this.bad
+	this.lock.Unlock()
+	if bad {
+		return false
+	}
+	if validator, ok := this.conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// pinnedConnector 只返回所属的连接，连接被丢弃后标记为失效，由外层连接池关闭
+type pinnedConnector struct {
+	conn *contextConn
+	used bool
+}
+
+func (this *pinnedConnector) Connect(context.Context) (driver.Conn, error) {
+	this.conn.lock.Lock()
+	defer this.conn.lock.Unlock()
+	if this.used {
+		this.conn.bad = true
+		return nil, driver.ErrBadConn
+	}
+	this.used = true
+	return pinnedConn{this.conn}, nil
+}
+
+func (this *pinnedConnector) Driver() driver.Driver {
+	return this.conn.driver.Driver
+}
+
+// pinnedConn Close不关闭连接，连接由外层连接池管理
+type pinnedConn struct {
+	*contextConn
+}
+
+func (this pinnedConn) Close() error {
+	return nil
+}
+
+type contextStmt struct {
+	stmt driver.Stmt
+	conn *contextConn
+}
+
+func (this *contextStmt) Close() error {
+	return this.stmt.Close()
+}
+
+func (this *contextStmt) NumInput() int {
+	return this.stmt.NumInput()
+}
+
+func (this *contextStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return this.stmt.Exec(args) //nolint:staticcheck
+}
+
+func (this *contextStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return this.stmt.Query(args) //nolint:staticcheck
+}
+
+func (this *contextStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if execer, ok := this.stmt.(driver.StmtExecContext); ok {
+		return execer.ExecContext(this.conn.context(ctx), args)
+	}
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return this.stmt.Exec(values) //nolint:staticcheck
+}
+
+func (this *contextStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, ok := this.stmt.(driver.StmtQueryContext); ok {
+		return queryer.QueryContext(this.conn.context(ctx), args)
+	}
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return this.stmt.Query(values) //nolint:staticcheck
+}
+
+// CheckNamedValue database/sql只使用stmt或conn其中一个的检查，需依次转发
+func (this *contextStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := this.stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	if converter, ok := this.stmt.(driver.ColumnConverter); ok { //nolint:staticcheck
+		value, err := converter.ColumnConverter(nv.Ordinal - 1).ConvertValue(nv.Value)
+		if err != nil {
+			return err
+		}
+		nv.Value = value
+		return nil
+	}
+	return this.conn.CheckNamedValue(nv)
+}
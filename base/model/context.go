@@ -0,0 +1,258 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	gorp "gopkg.in/gorp.v1"
+)
+
+// sqlRunner 执行sql，gorp.SqlExecutor与contextRunner均实现该接口
+type sqlRunner interface {
+	Select(i interface{}, query string, args ...interface{}) ([]interface{}, error)
+	SelectInt(query string, args ...interface{}) (int64, error)
+	SelectStr(query string, args ...interface{}) (string, error)
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Insert(list ...interface{}) error
+	Update(list ...interface{}) (int64, error)
+}
+
+// contextRunner 每次调用生成单独的语句ctx，通过run交给gorp执行
+type contextRunner struct {
+	ctx     context.Context
+	timeout time.Duration
+	run     func(ctx context.Context, fn func(exec gorp.SqlExecutor) error) error
+}
+
+// SetTimeout 设置语句的默认超时，每条语句单独计时，0为不限制.
+func (this *CommonModel) SetTimeout(timeout time.Duration) {
+	this.timeout = timeout
+}
+
+// Timeout 语句的默认超时.
+func (this *CommonModel) Timeout() time.Duration {
+	return this.timeout
+}
+
+// contextual 是否绑定了context或设置了超时
+func (this *CommonModel) contextual() bool {
+	return this.ctx != nil || this.timeout > 0
+}
+
+// executor 带context的Executor
+func (this *CommonModel) executor() sqlRunner {
+	return this.runner(this.Executor())
+}
+
+// reader 带context的Reader
+func (this *CommonModel) reader() sqlRunner {
+	return this.runner(this.Reader())
+}
+
+// runner 绑定了context或设置了超时时，exec为DbMap的语句在单独取出的连接上执行，为事务的语句替换事务连接的ctx后执行.
+// 事务中未绑定context的model使用TransactionContext的ctx；启用审计的model在事务中的语句标记为经过审计.
+func (this *CommonModel) runner(exec gorp.SqlExecutor) sqlRunner {
+	if !this.contextual() && this.audit == nil {
+		return exec
+	}
+	runner := &contextRunner{ctx: this.Context(), timeout: this.timeout}
+	switch e := exec.(type) {
+	case *gorp.DbMap:
+		if !this.contextual() {
+			return exec
+		}
+		runner.run = func(ctx context.Context, fn func(exec gorp.SqlExecutor) error) error {
+			return withConn(ctx, e, func(m *gorp.DbMap, _ *contextConn) error { return fn(m) })
+		}
+	case *gorp.Transaction:
+		tx := this.tx
+		if tx == nil || tx.Transaction != e || tx.conn == nil {
+			return exec
+		}
+		if this.ctx == nil {
+			runner.ctx = tx.ctx
+		}
+		runner.run = func(ctx context.Context, fn func(exec gorp.SqlExecutor) error) error {
+			return tx.conn.use(ctx, func() error { return fn(e) })
+		}
+	default:
+		return exec
+	}
+	if this.audit != nil {
+		runner.ctx = context.WithValue(runner.ctx, auditedCtxKey{}, true)
+	}
+	return runner
+}
+
+// withRunner 使用runner的语句ctx执行fn
+func (this *CommonModel) withRunner(exec gorp.SqlExecutor, fn func(exec gorp.SqlExecutor) error) error {
+	if runner, ok := this.runner(exec).(*contextRunner); ok {
+		return runner.exec(fn)
+	}
+	return fn(exec)
+}
+
+func (this *contextRunner) exec(fn func(exec gorp.SqlExecutor) error) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if this.timeout > 0 {
+		ctx, cancel = context.WithTimeout(this.ctx, this.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(this.ctx)
+	}
+	defer cancel()
+	return this.run(ctx, fn)
+}
+
+func (this *contextRunner) Select(i interface{}, query string, args ...interface{}) (list []interface{}, err error) {
+	err = this.exec(func(exec gorp.SqlExecutor) error {
+		list, err = exec.Select(i, query, args...)
+		return err
+	})
+	return list, err
+}
+
+func (this *contextRunner) SelectInt(query string, args ...interface{}) (value int64, err error) {
+	err = this.exec(func(exec gorp.SqlExecutor) error {
+		value, err = exec.SelectInt(query, args...)
+		return err
+	})
+	return value, err
+}
+
+func (this *contextRunner) SelectStr(query string, args ...interface{}) (value string, err error) {
+	err = this.exec(func(exec gorp.SqlExecutor) error {
+		value, err = exec.SelectStr(query, args...)
+		return err
+	})
+	return value, err
+}
+
+func (this *contextRunner) Exec(query string, args ...interface{}) (result sql.Result, err error) {
+	err = this.exec(func(exec gorp.SqlExecutor) error {
+		result, err = exec.Exec(query, args...)
+		return err
+	})
+	return result, err
+}
+
+func (this *contextRunner) Insert(list ...interface{}) error {
+	return this.exec(func(exec gorp.SqlExecutor) error {
+		return exec.Insert(list...)
+	})
+}
+
+func (this *contextRunner) Update(list ...interface{}) (rows int64, err error) {
+	err = this.exec(func(exec gorp.SqlExecutor) error {
+		rows, err = exec.Update(list...)
+		return err
+	})
+	return rows, err
+}
+
+// insert 插入一条记录
+func (this *CommonModel) insert(model interface{}) error {
+	return this.executor().Insert(model)
+}
+
+// updateStruct 按主键更新整条记录，版本号不一致时返回VersionConflictError
+func (this *CommonModel) updateStruct(model interface{}) (int, error) {
+	rows, err := this.executor().Update(model)
+	return int(rows), versionConflictOf(err)
+}
+
+// withContext 绑定ctx的副本
+func (this *CommonModel) withContext(ctx context.Context) *CommonModel {
+	return WithContext(this.GetModel(), ctx).(commonGetter).common()
+}
+
+// CreateContext 同Create，ctx取消或超时时中断执行.
+func (this *CommonModel) CreateContext(ctx context.Context, model interface{}) (int, error) {
+	return this.withContext(ctx).Create(model)
+}
+
+// UpdateModelContext 同UpdateModel.
+func (this *CommonModel) UpdateModelContext(ctx context.Context, model interface{}) (int, error) {
+	return this.withContext(ctx).UpdateModel(model)
+}
+
+// UpdateByIdContext 同UpdateById.
+func (this *CommonModel) UpdateByIdContext(ctx context.Context, fields map[string]interface{}, id ...int) (int, error) {
+	return this.withContext(ctx).UpdateById(fields, id...)
+}
+
+// UpdateByConditionContext 同UpdateByCondition.
+func (this *CommonModel) UpdateByConditionContext(ctx context.Context, fields map[string]interface{}, conditions QueryMap) (int, error) {
+	return this.withContext(ctx).UpdateByCondition(fields, conditions)
+}
+
+// DeleteByIdContext 同DeleteById.
+func (this *CommonModel) DeleteByIdContext(ctx context.Context, id ...int) (int, error) {
+	return this.withContext(ctx).DeleteById(id...)
+}
+
+// RestoreByIdContext 同RestoreById.
+func (this *CommonModel) RestoreByIdContext(ctx context.Context, id ...int) (int, error) {
+	return this.withContext(ctx).RestoreById(id...)
+}
+
+// HardDeleteByIdContext 同HardDeleteById.
+func (this *CommonModel) HardDeleteByIdContext(ctx context.Context, id ...int) (int, error) {
+	return this.withContext(ctx).HardDeleteById(id...)
+}
+
+// PurgeDeletedContext 同PurgeDeleted.
+func (this *CommonModel) PurgeDeletedContext(ctx context.Context, conditions QueryMap) (int, error) {
+	return this.withContext(ctx).PurgeDeleted(conditions)
+}
+
+// GetInterfaceByIdContext 同GetInterfaceById.
+func (this *CommonModel) GetInterfaceByIdContext(ctx context.Context, dataType interface{}, id ...int) ([]interface{}, error) {
+	return this.withContext(ctx).GetInterfaceById(dataType, id...)
+}
+
+// SearchInterfaceContext 同SearchInterface.
+func (this *CommonModel) SearchInterfaceContext(ctx context.Context, page int, pageSize int, sort string, query map[string]string, genCondition func(map[string]string) QueryMap, dataType interface{}) ([]interface{}, int, error) {
+	return this.withContext(ctx).SearchInterface(page, pageSize, sort, query, genCondition, dataType)
+}
+
+// SearchAllInterfaceContext 同SearchAllInterface.
+func (this *CommonModel) SearchAllInterfaceContext(ctx context.Context, sort string, query map[string]string, genCondition func(map[string]string) QueryMap, dataType interface{}) ([]interface{}, error) {
+	return this.withContext(ctx).SearchAllInterface(sort, query, genCondition, dataType)
+}
+
+// SearchByCursorContext 同SearchByCursor.
+func (this *CommonModel) SearchByCursorContext(ctx context.Context, cursor string, pageSize int, sort string, query map[string]string, genCondition func(map[string]string) QueryMap, dataType interface{}, countMode CountMode) ([]interface{}, *CursorPage, error) {
+	return this.withContext(ctx).SearchByCursor(cursor, pageSize, sort, query, genCondition, dataType, countMode)
+}
+
+// SelectQueryContext 同SelectQuery.
+func (this *CommonModel) SelectQueryContext(ctx context.Context, dataType interface{}, query *SelectBuilder) ([]interface{}, error) {
+	return this.withContext(ctx).SelectQuery(dataType, query)
+}
+
+// CountQueryContext 同CountQuery.
+func (this *CommonModel) CountQueryContext(ctx context.Context, query *SelectBuilder) (int, error) {
+	return this.withContext(ctx).CountQuery(query)
+}
+
+// EstimateCountContext 同EstimateCount.
+func (this *CommonModel) EstimateCountContext(ctx context.Context, query *SelectBuilder) (int, error) {
+	return this.withContext(ctx).EstimateCount(query)
+}
+
+// CreateBatchContext 同CreateBatch.
+func (this *CommonModel) CreateBatchContext(ctx context.Context, models interface{}, chunkSize int) ([]int, error) {
+	return this.withContext(ctx).CreateBatch(models, chunkSize)
+}
+
+// UpsertContext 同Upsert.
+func (this *CommonModel) UpsertContext(ctx context.Context, models interface{}, conflictKeys []string, updateFields []string, chunkSize int) ([]int, error) {
+	return this.withContext(ctx).Upsert(models, conflictKeys, updateFields, chunkSize)
+}
+
+// UpdateBatchByIdContext 同UpdateBatchById.
+func (this *CommonModel) UpdateBatchByIdContext(ctx context.Context, rows map[int]map[string]interface{}, chunkSize int) ([]int, error) {
+	return this.withContext(ctx).UpdateBatchById(rows, chunkSize)
+}
@@ -0,0 +1,296 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	gorp "gopkg.in/gorp.v1"
+)
+
+// CountMode 游标分页的总数统计方式
+type CountMode int
+
+const (
+	// CountNone 不统计总数
+	CountNone CountMode = iota
+	// CountExact 使用count统计准确总数
+	CountExact
+	// CountEstimate 使用执行计划估算总数，仅支持mysql与postgres，其他情况使用count
+	CountEstimate
+)
+
+// ErrInvalidCursor 游标无法解析或与排序不匹配
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CursorPage 游标分页结果
+type CursorPage struct {
+	// 下一页的游标，没有下一页时为空
+	NextCursor string
+	HasMore    bool
+	// 总数，CountNone时为-1
+	Total int
+}
+
+// SearchByCursor 游标分页查询，cursor为上一页返回的NextCursor，第一页传空.
+// 按sort排序，末尾自动追加id保证顺序唯一，以上一页最后一条记录的排序字段值作为条件，不使用offset，翻页深度不影响性能.
+// 排序字段不能为null，翻页时需使用与第一页相同的sort，pageSize需大于0.
+func (this *CommonModel) SearchByCursor(cursor string, pageSize int, sort string, query map[string]string, genCondition func(map[string]string) QueryMap, dataType interface{}, countMode CountMode) ([]interface{}, *CursorPage, error) {
+	if pageSize <= 0 {
+		return nil, nil, fmt.Errorf("invalid pageSize %d", pageSize)
+	}
+	elemType, err := elemTypeOf(dataType)
+	if err != nil {
+		return nil, nil, err
+	}
+	orders := this.cursorOrders(sort)
+	q := this.Query()
+	if genCondition != nil {
+		q.Where(genCondition(query).Cond())
+	}
+
+	page := &CursorPage{Total: -1}
+	switch countMode {
+	case CountExact:
+		page.Total, err = this.CountQuery(q)
+	case CountEstimate:
+		page.Total, err = this.EstimateCount(q)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cursor != "" {
+		values, err := decodeCursor(cursor, elemType, orders)
+		if err != nil {
+			return nil, nil, err
+		}
+		q.Where(keysetCond(orders, values))
+	}
+	for _, order := range orders {
+		if order.desc {
+			q.OrderBy(order.field + " desc")
+		} else {
+			q.OrderBy(order.field)
+		}
+	}
+
+	// 多查一条判断是否有下一页
+	var dest reflect.Value
+	destLen := 0
+	if v := reflect.ValueOf(dataType); v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice {
+		dest = v.Elem()
+		destLen = dest.Len()
+	}
+	list, err := this.SelectQuery(dataType, q.Limit(pageSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	var last reflect.Value
+	if dest.IsValid() {
+		if dest.Len()-destLen > pageSize {
+			page.HasMore = true
+			dest.SetLen(destLen + pageSize)
+		}
+		if dest.Len() > destLen {
+			last = dest.Index(dest.Len() - 1)
+		}
+	} else {
+		if len(list) > pageSize {
+			page.HasMore = true
+			list = list[:pageSize]
+		}
+		if len(list) > 0 {
+			last = reflect.ValueOf(list[len(list)-1])
+		}
+	}
+	if page.HasMore && last.IsValid() {
+		if page.NextCursor, err = encodeCursor(reflect.Indirect(last), orders); err != nil {
+			return nil, nil, err
+		}
+	}
+	return list, page, nil
+}
+
+// EstimateCount 按执行计划估算查询的总数，mysql使用explain的rows，postgres使用explain的Plan Rows，其他数据库或事务中使用count.
+func (this *CommonModel) EstimateCount(query *SelectBuilder) (int, error) {
+	if err := CheckCond(query); err != nil {
+		return 0, err
+	}
+	switch this.Dialect().Name() {
+	case "postgres":
+		// count语句的计划行数为1，需估算原语句
+		sql, params := query.ToSql(this.Dialect())
+		plan, err := this.reader().SelectStr("explain (format json) "+sql, params...)
+		if err != nil {
+			return 0, err
+		}
+		var plans []struct {
+			Plan struct {
+				PlanRows float64 `json:"Plan Rows"`
+			}
+		}
+		if err = json.Unmarshal([]byte(plan), &plans); err != nil || len(plans) == 0 {
+			return 0, fmt.Errorf("unexpected explain output: %v", plan)
+		}
+		return int(plans[0].Plan.PlanRows), nil
+	case "mysql":
+		if reader, ok := this.Reader().(*gorp.DbMap); ok {
+			sql, params := query.ToSql(this.Dialect())
+			var rows int
+			err := this.withRunner(reader, func(exec gorp.SqlExecutor) (err error) {
+				rows, err = explainRows(exec.(*gorp.DbMap).Db, "explain "+sql, params...)
+				return err
+			})
+			return rows, err
+		}
+	}
+	return this.CountQuery(query)
+}
+
+// cursorOrders 游标分页的排序，未包含id时追加id，方向与最后一个排序字段相同
+func (this *CommonModel) cursorOrders(sort string) []orderField {
+	orders := this.orderFields(sort)
+	desc := true
+	for i, order := range orders {
+		orders[i].field = strings.Trim(order.field, "`\"")
+		if strings.EqualFold(orders[i].field, "id") {
+			return orders[:i+1]
+		}
+		desc = order.desc
+	}
+	return append(orders, orderField{field: "id", desc: desc})
+}
+
+// keysetCond 位于游标之后的条件，如(a > ?) or (a = ? and id > ?)
+func keysetCond(orders []orderField, values []interface{}) Cond {
+	conds := make([]Cond, 0, len(orders))
+	for i, order := range orders {
+		items := make([]Cond, 0, i+1)
+		for j := 0; j < i; j++ {
+			items = append(items, Eq(orders[j].field, values[j]))
+		}
+		op := ">"
+		if order.desc {
+			op = "<"
+		}
+		items = append(items, Compare(order.field, op, values[i]))
+		conds = append(conds, And(items...))
+	}
+	return Or(conds...)
+}
+
+func encodeCursor(v reflect.Value, orders []orderField) (string, error) {
+	values := make([]interface{}, 0, len(orders))
+	for _, order := range orders {
+		index, ok := columnIndex(v.Type(), order.field)
+		if !ok {
+			return "", fmt.Errorf("field %v not found in %v", order.field, v.Type())
+		}
+		values = append(values, v.FieldByIndex(index).Interface())
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor 按结构体字段类型解析游标中的值
+func decodeCursor(cursor string, elemType reflect.Type, orders []orderField) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var raws []json.RawMessage
+	if err = json.Unmarshal(data, &raws); err != nil || len(raws) != len(orders) {
+		return nil, ErrInvalidCursor
+	}
+	values := make([]interface{}, 0, len(orders))
+	for i, order := range orders {
+		index, ok := columnIndex(elemType, order.field)
+		if !ok {
+			return nil, fmt.Errorf("field %v not found in %v", order.field, elemType)
+		}
+		value := reflect.New(elemType.FieldByIndex(index).Type)
+		if err = json.Unmarshal(raws[i], value.Interface()); err != nil {
+			return nil, ErrInvalidCursor
+		}
+		values = append(values, value.Elem().Interface())
+	}
+	return values, nil
+}
+
+// elemTypeOf dataType对应的结构体类型，dataType可以为T、*T或*[]*T
+func elemTypeOf(dataType interface{}) (reflect.Type, error) {
+	t := reflect.TypeOf(dataType)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("dataType must be a struct, got %T", dataType)
+	}
+	return t, nil
+}
+
+// columnIndex 查找db标签为column的字段，包括匿名嵌入的结构体，与gorp一致不区分大小写
+func columnIndex(t reflect.Type, column string) ([]int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			if index, ok := columnIndex(field.Type, column); ok {
+				return append([]int{i}, index...), true
+			}
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		if strings.EqualFold(tag, column) {
+			return []int{i}, true
+		}
+	}
+	return nil, false
+}
+
+// explainRows 取mysql explain结果第一行的rows
+func explainRows(db *sql.DB, query string, args ...interface{}) (int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+	for i, column := range columns {
+		if strings.EqualFold(column, "rows") {
+			if !values[i].Valid {
+				return 0, nil
+			}
+			return strconv.Atoi(values[i].String)
+		}
+	}
+	return 0, fmt.Errorf("unexpected explain output: %v", columns)
+}
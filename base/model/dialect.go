@@ -0,0 +1,91 @@
+package model
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	gorp "gopkg.in/gorp.v1"
+)
+
+// Dialect 不同数据库的sql差异
+type Dialect interface {
+	// Name 数据库名，如mysql、sqlite
+	Name() string
+	// Quote 转义字段名或表名
+	Quote(name string) string
+	// Placeholder 第index个参数的占位符，index从0开始
+	Placeholder(index int) string
+}
+
+var (
+	// MysqlDialect mysql
+	MysqlDialect Dialect = mysqlDialect{}
+	// SqliteDialect sqlite
+	SqliteDialect Dialect = sqliteDialect{}
+	// PostgresDialect postgres
+	PostgresDialect Dialect = postgresDialect{}
+)
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string                 { return "mysql" }
+func (mysqlDialect) Quote(name string) string     { return "`" + name + "`" }
+func (mysqlDialect) Placeholder(index int) string { return "?" }
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string                 { return "sqlite" }
+func (sqliteDialect) Quote(name string) string     { return `"` + name + `"` }
+func (sqliteDialect) Placeholder(index int) string { return "?" }
+
+// postgresDialect 与gorp.PostgresDialect一致，字段名转为小写后转义
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string                 { return "postgres" }
+func (postgresDialect) Quote(name string) string     { return `"` + strings.ToLower(name) + `"` }
+func (postgresDialect) Placeholder(index int) string { return "$" + strconv.Itoa(index+1) }
+
+// DialectOf 获取gorp方言对应的Dialect，未知的方言按mysql处理
+func DialectOf(dialect gorp.Dialect) Dialect {
+	switch dialect.(type) {
+	case gorp.SqliteDialect, *gorp.SqliteDialect:
+		return SqliteDialect
+	case gorp.PostgresDialect, *gorp.PostgresDialect:
+		return PostgresDialect
+	}
+	return MysqlDialect
+}
+
+var identRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// quoteIdent 转义字段名，支持table.field与table.*，其他表达式（如count(id)、已转义的字段）原样返回
+func quoteIdent(dialect Dialect, name string) string {
+	name = strings.TrimSpace(name)
+	if name == "*" {
+		return name
+	}
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		if part == "*" && i == len(parts)-1 && i > 0 {
+			continue
+		}
+		if !identRegexp.MatchString(part) {
+			return name
+		}
+		parts[i] = dialect.Quote(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// quoteTable 转义表名，支持"table alias"与"table as alias"
+func quoteTable(dialect Dialect, table string) string {
+	parts := strings.Fields(table)
+	switch {
+	case len(parts) == 2:
+		return quoteIdent(dialect, parts[0]) + " " + quoteIdent(dialect, parts[1])
+	case len(parts) == 3 && strings.ToLower(parts[1]) == "as":
+		return quoteIdent(dialect, parts[0]) + " as " + quoteIdent(dialect, parts[2])
+	}
+	return quoteIdent(dialect, table)
+}
@@ -0,0 +1,461 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	gorp "gopkg.in/gorp.v1"
+)
+
+const (
+	defaultMigrationTable   = "schema_migrations"
+	defaultMigrateLockWait  = time.Minute
+	migrateLockStaleTimeout = 10 * time.Minute
+	// 持有锁期间刷新lockedTime的间隔，需远小于migrateLockStaleTimeout
+	migrateLockRefresh = time.Minute
+)
+
+// ErrMigrateLocked 其他进程正在执行迁移
+var ErrMigrateLocked = errors.New("migration is locked by another process")
+
+var migrationFileRegexp = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration 一个版本的迁移，Up/Down与UpSql/DownSql二选一，Up/Down在事务中执行.
+// mysql的DDL语句会隐式提交事务，包含多条语句的迁移中途失败时已执行的语句不会回滚，且版本不会记录，
+// 需手动修复后重试，建议mysql的每个迁移只包含一条DDL.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *Tx) error
+	Down    func(tx *Tx) error
+	UpSql   string
+	DownSql string
+}
+
+// MigrationStatus 迁移状态
+type MigrationStatus struct {
+	Version     int64  `json:"version"`
+	Name        string `json:"name"`
+	Applied     bool   `json:"applied"`
+	AppliedTime string `json:"appliedTime"`
+}
+
+// MigrateOptions 迁移配置
+type MigrateOptions struct {
+	// 迁移文件所在的文件系统，一般为embed.FS，文件名格式为{version}_{name}.up.sql与{version}_{name}.down.sql
+	Fsys fs.FS
+	// 迁移文件所在的目录，默认为根目录
+	Dir string
+	// Go实现的迁移，与文件中的迁移版本号不能重复
+	Migrations []*Migration
+
+	// 记录已执行版本的表名，默认schema_migrations
+	Table string
+	// 只输出将要执行的迁移，不实际执行
+	DryRun bool
+	// 等待其他进程释放迁移锁的时间，默认1分钟
+	LockWait time.Duration
+	// 模块Init时自动执行所有未执行的迁移
+	AutoRun bool
+}
+
+// Migrator 数据库迁移
+type Migrator struct {
+	dbMap      *gorp.DbMap
+	dialect    Dialect
+	options    *MigrateOptions
+	migrations []*Migration
+}
+
+// NewMigrator 创建迁移，加载options中的文件与Go迁移
+func NewMigrator(dbMap *gorp.DbMap, options *MigrateOptions) (*Migrator, error) {
+	if options == nil {
+		options = &MigrateOptions{}
+	}
+	if len(options.Table) == 0 {
+		options.Table = defaultMigrationTable
+	}
+	if options.LockWait <= 0 {
+		options.LockWait = defaultMigrateLockWait
+	}
+	this := &Migrator{dbMap: dbMap, dialect: DialectOf(dbMap.Dialect), options: options}
+	if options.Fsys != nil {
+		if err := this.loadFS(options.Fsys, options.Dir); err != nil {
+			return nil, err
+		}
+	}
+	if err := this.Add(options.Migrations...); err != nil {
+		return nil, err
+	}
+	return this, nil
+}
+
+// Add 添加迁移
+func (this *Migrator) Add(migrations ...*Migration) error {
+	for _, migration := range migrations {
+		if this.find(migration.Version) != nil {
+			return fmt.Errorf("duplicate migration version %v", migration.Version)
+		}
+		this.migrations = append(this.migrations, migration)
+	}
+	sort.Slice(this.migrations, func(i, j int) bool {
+		return this.migrations[i].Version < this.migrations[j].Version
+	})
+	return nil
+}
+
+func (this *Migrator) find(version int64) *Migration {
+	for _, migration := range this.migrations {
+		if migration.Version == version {
+			return migration
+		}
+	}
+	return nil
+}
+
+func (this *Migrator) loadFS(fsys fs.FS, dir string) error {
+	if len(dir) == 0 {
+		dir = "."
+	}
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	files := make(map[int64]*Migration)
+	for _, entry := range entries {
+		matches := migrationFileRegexp.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		migration, ok := files[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: matches[2]}
+			files[version] = migration
+		} else if migration.Name != matches[2] {
+			return fmt.Errorf("duplicate migration version %v", version)
+		}
+		if matches[3] == "up" {
+			migration.UpSql = string(content)
+		} else {
+			migration.DownSql = string(content)
+		}
+	}
+	for _, migration := range files {
+		if err := this.Add(migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Status 所有迁移的执行状态
+func (this *Migrator) Status() ([]*MigrationStatus, error) {
+	applied, err := this.applied()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*MigrationStatus, 0, len(this.migrations))
+	for _, migration := range this.migrations {
+		status := &MigrationStatus{Version: migration.Version, Name: migration.Name}
+		status.AppliedTime, status.Applied = applied[migration.Version]
+		list = append(list, status)
+	}
+	return list, nil
+}
+
+// Up 按版本号顺序执行所有未执行的迁移
+func (this *Migrator) Up() error {
+	return this.withLock(func() error {
+		applied, err := this.applied()
+		if err != nil {
+			return err
+		}
+		for _, migration := range this.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err = this.run(migration, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down 回滚最近执行的steps个迁移
+func (this *Migrator) Down(steps int) error {
+	return this.withLock(func() error {
+		applied, err := this.applied()
+		if err != nil {
+			return err
+		}
+		for i := len(this.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := this.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == nil && len(strings.TrimSpace(migration.DownSql)) == 0 {
+				return fmt.Errorf("migration %v_%v has no down migration", migration.Version, migration.Name)
+			}
+			if err = this.run(migration, false); err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+func (this *Migrator) run(migration *Migration, up bool) error {
+	direction, fn, sqlStr := "up", migration.Up, migration.UpSql
+	if !up {
+		direction, fn, sqlStr = "down", migration.Down, migration.DownSql
+	}
+	entry := logrus.WithFields(logrus.Fields{"@type": "migration", "version": migration.Version, "name": migration.Name, "direction": direction})
+	if this.options.DryRun {
+		entry.WithField("sql", sqlStr).Info("migration dry run")
+		return nil
+	}
+	start := time.Now()
+	err := Transaction(this.dbMap, func(tx *Tx) error {
+		if fn != nil {
+			if err := fn(tx); err != nil {
+				return err
+			}
+		}
+		for _, statement := range splitStatements(sqlStr) {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		table := this.dialect.Quote(this.options.Table)
+		if up {
+			_, err := tx.Exec(fmt.Sprintf("insert into %v (%v,%v,%v) values (%v,%v,%v)", table,
+				this.dialect.Quote("version"), this.dialect.Quote("name"), this.dialect.Quote("appliedTime"),
+				this.dialect.Placeholder(0), this.dialect.Placeholder(1), this.dialect.Placeholder(2)),
+				migration.Version, migration.Name, time.Now().Format("2006-01-02 15:04:05"))
+			return err
+		}
+		_, err := tx.Exec(fmt.Sprintf("delete from %v where %v=%v", table, this.dialect.Quote("version"), this.dialect.Placeholder(0)), migration.Version)
+		return err
+	})
+	if err != nil {
+		entry.WithError(err).Error("migration failed")
+		return fmt.Errorf("migration %v_%v %v: %w", migration.Version, migration.Name, direction, err)
+	}
+	entry.WithField("duration", time.Since(start).String()).Info("migration applied")
+	return nil
+}
+
+// applied 已执行的版本及执行时间，dry run时表不存在视为未执行任何迁移
+func (this *Migrator) applied() (map[int64]string, error) {
+	if !this.options.DryRun {
+		if err := this.createTable(); err != nil {
+			return nil, err
+		}
+	}
+	rows, err := this.dbMap.Db.Query(fmt.Sprintf("select %v,%v from %v", this.dialect.Quote("version"), this.dialect.Quote("appliedTime"), this.dialect.Quote(this.options.Table)))
+	if err != nil {
+		if this.options.DryRun {
+			return map[int64]string{}, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int64]string)
+	for rows.Next() {
+		var version int64
+		var appliedTime string
+		if err = rows.Scan(&version, &appliedTime); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedTime
+	}
+	return applied, rows.Err()
+}
+
+func (this *Migrator) createTable() error {
+	_, err := this.dbMap.Exec(fmt.Sprintf("create table if not exists %v (%v bigint not null primary key, %v varchar(255) not null, %v varchar(32) not null)",
+		this.dialect.Quote(this.options.Table), this.dialect.Quote("version"), this.dialect.Quote("name"), this.dialect.Quote("appliedTime")))
+	return err
+}
+
+// withLock 获取迁移锁后执行fn，mysql使用get_lock，postgres使用advisory lock，其他数据库使用锁表
+func (this *Migrator) withLock(fn func() error) error {
+	if this.options.DryRun {
+		return fn()
+	}
+	switch this.dialect.Name() {
+	case MysqlDialect.Name():
+		return this.withMysqlLock(fn)
+	case PostgresDialect.Name():
+		return this.withPostgresLock(fn)
+	}
+	return this.withTableLock(fn)
+}
+
+func (this *Migrator) withMysqlLock(fn func() error) error {
+	ctx := context.Background()
+	// get_lock与release_lock需在同一连接中执行
+	conn, err := this.dbMap.Db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	lockName := "migrate:" + this.options.Table
+	var locked int
+	if err = conn.QueryRowContext(ctx, "select get_lock(?, ?)", lockName, int(this.options.LockWait.Seconds())).Scan(&locked); err != nil {
+		return err
+	}
+	if locked != 1 {
+		return ErrMigrateLocked
+	}
+	defer conn.ExecContext(ctx, "select release_lock(?)", lockName)
+	return fn()
+}
+
+func (this *Migrator) withPostgresLock(fn func() error) error {
+	ctx := context.Background()
+	conn, err := this.dbMap.Db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	hash := fnv.New64a()
+	hash.Write([]byte("migrate:" + this.options.Table))
+	lockKey := int64(hash.Sum64())
+	deadline := time.Now().Add(this.options.LockWait)
+	for {
+		var locked bool
+		if err = conn.QueryRowContext(ctx, "select pg_try_advisory_lock($1)", lockKey).Scan(&locked); err != nil {
+			return err
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			return ErrMigrateLocked
+		}
+		time.Sleep(time.Second)
+	}
+	defer conn.ExecContext(ctx, "select pg_advisory_unlock($1)", lockKey)
+	return fn()
+}
+
+func (this *Migrator) withTableLock(fn func() error) error {
+	table := this.dialect.Quote(this.options.Table + "_lock")
+	id, lockedTime := this.dialect.Quote("id"), this.dialect.Quote("lockedTime")
+	_, err := this.dbMap.Exec(fmt.Sprintf("create table if not exists %v (%v int not null primary key, %v bigint not null)", table, id, lockedTime))
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(this.options.LockWait)
+	for {
+		// 进程异常退出时锁不会释放，超时的锁视为失效
+		stale := time.Now().Add(-migrateLockStaleTimeout).Unix()
+		this.dbMap.Exec(fmt.Sprintf("delete from %v where %v=1 and %v<%v", table, id, lockedTime, this.dialect.Placeholder(0)), stale)
+		_, err = this.dbMap.Exec(fmt.Sprintf("insert into %v (%v,%v) values (1,%v)", table, id, lockedTime, this.dialect.Placeholder(0)), time.Now().Unix())
+		if err == nil {
+			break
+		}
+		// 只有主键冲突表示锁被占用，其他错误直接返回
+		if !isDuplicateKey(err) && !IsDeadlock(err) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return ErrMigrateLocked
+		}
+		time.Sleep(time.Second)
+	}
+	defer this.dbMap.Exec(fmt.Sprintf("delete from %v where %v=1", table, id))
+
+	// 迁移耗时较长时刷新lockedTime，避免被其他进程视为失效
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(migrateLockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_, err := this.dbMap.Exec(fmt.Sprintf("update %v set %v=%v where %v=1", table, lockedTime, this.dialect.Placeholder(0), id), time.Now().Unix())
+				if err != nil {
+					logrus.WithError(err).Warn("refresh migration lock failed")
+				}
+			}
+		}
+	}()
+	return fn()
+}
+
+// isDuplicateKey 是否为唯一键冲突
+func isDuplicateKey(err error) bool {
+	msg := err.Error()
+	for _, keyword := range duplicateKeyKeywords {
+		if strings.Contains(msg, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+var duplicateKeyKeywords = []string{
+	"Error 1062", // mysql ER_DUP_ENTRY
+	"UNIQUE constraint failed",
+	"duplicate key value violates unique constraint",
+}
+
+// splitStatements 按;拆分sql语句，忽略引号中的;与--注释
+func splitStatements(sqlStr string) []string {
+	statements := []string{}
+	var current strings.Builder
+	var quote rune
+	lines := strings.Split(sqlStr, "\n")
+	for _, line := range lines {
+		if quote == 0 && strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		for _, c := range line {
+			switch {
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case c == '\'' || c == '"' || c == '`':
+				quote = c
+			case c == ';':
+				if statement := strings.TrimSpace(current.String()); len(statement) > 0 {
+					statements = append(statements, statement)
+				}
+				current.Reset()
+				continue
+			}
+			current.WriteRune(c)
+		}
+		current.WriteString("\n")
+	}
+	if statement := strings.TrimSpace(current.String()); len(statement) > 0 {
+		statements = append(statements, statement)
+	}
+	return statements
+}
@@ -0,0 +1,136 @@
+package model
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultPoolSaturation = 0.8
+
+// PoolConfig 连接池配置，字段为0时使用模块的默认值
+type PoolConfig struct {
+	// 最大空闲连接数，小于0时不保留空闲连接
+	MaxIdleConns int
+	// 最大连接数，小于0时不限制
+	MaxOpenConns int
+	// 连接最长使用时间，小于0时不限制
+	ConnMaxLifetime time.Duration
+	// 连接最长空闲时间，小于0时不限制
+	ConnMaxIdleTime time.Duration
+}
+
+// Merge 使用defaults补全为0的字段，返回新的配置
+func (this *PoolConfig) Merge(defaults *PoolConfig) *PoolConfig {
+	merged := *defaults
+	if this == nil {
+		return &merged
+	}
+	if this.MaxIdleConns != 0 {
+		merged.MaxIdleConns = this.MaxIdleConns
+	}
+	if this.MaxOpenConns != 0 {
+		merged.MaxOpenConns = this.MaxOpenConns
+	}
+	if this.ConnMaxLifetime != 0 {
+		merged.ConnMaxLifetime = this.ConnMaxLifetime
+	}
+	if this.ConnMaxIdleTime != 0 {
+		merged.ConnMaxIdleTime = this.ConnMaxIdleTime
+	}
+	return &merged
+}
+
+// Apply 设置到db
+func (this *PoolConfig) Apply(db *sql.DB) {
+	db.SetMaxIdleConns(this.MaxIdleConns)
+	db.SetMaxOpenConns(nonNegative(this.MaxOpenConns))
+	db.SetConnMaxLifetime(time.Duration(nonNegative(int(this.ConnMaxLifetime))))
+	db.SetConnMaxIdleTime(time.Duration(nonNegative(int(this.ConnMaxIdleTime))))
+}
+
+func nonNegative(value int) int {
+	if value < 0 {
+		return 0
+	}
+	return value
+}
+
+// PoolMonitor 定时输出连接池状态，使用中的连接数达到最大连接数的saturation比例或出现等待时输出警告
+type PoolMonitor struct {
+	tp         string
+	dbs        map[string]*sql.DB
+	saturation float64
+	waitCount  map[string]int64
+	done       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewPoolMonitor 创建并开始监控，tp为日志的@type，saturation为0时默认0.8
+func NewPoolMonitor(tp string, dbs map[string]*sql.DB, interval time.Duration, saturation float64) *PoolMonitor {
+	if saturation <= 0 {
+		saturation = defaultPoolSaturation
+	}
+	this := &PoolMonitor{
+		tp:         tp,
+		dbs:        dbs,
+		saturation: saturation,
+		waitCount:  make(map[string]int64),
+		done:       make(chan struct{}),
+	}
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				this.report()
+			case <-this.done:
+				return
+			}
+		}
+	}()
+	return this
+}
+
+func (this *PoolMonitor) report() {
+	names := make([]string, 0, len(this.dbs))
+	for name := range this.dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stats := this.dbs[name].Stats()
+		waits := stats.WaitCount - this.waitCount[name]
+		this.waitCount[name] = stats.WaitCount
+		entry := logrus.WithFields(logrus.Fields{
+			"@type":             this.tp,
+			"dbKey":             name,
+			"maxOpen":           stats.MaxOpenConnections,
+			"open":              stats.OpenConnections,
+			"inUse":             stats.InUse,
+			"idle":              stats.Idle,
+			"waitCount":         waits,
+			"waitDuration":      stats.WaitDuration.String(),
+			"maxIdleClosed":     stats.MaxIdleClosed,
+			"maxIdleTimeClosed": stats.MaxIdleTimeClosed,
+			"maxLifetimeClosed": stats.MaxLifetimeClosed,
+		})
+		if waits > 0 || (stats.MaxOpenConnections > 0 && float64(stats.InUse) >= this.saturation*float64(stats.MaxOpenConnections)) {
+			entry.Warn("db pool saturated")
+			continue
+		}
+		entry.Info("db pool stats")
+	}
+}
+
+// Stop 停止监控
+func (this *PoolMonitor) Stop() {
+	close(this.done)
+	this.wg.Wait()
+}
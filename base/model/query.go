@@ -0,0 +1,557 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Cond 查询条件，由Eq、In、And、Or等函数生成
+type Cond interface {
+	build(b *sqlBuilder)
+}
+
+// sqlBuilder 拼接sql与参数，同一条语句（包括子查询）共用一个sqlBuilder以保证占位符序号连续
+type sqlBuilder struct {
+	dialect Dialect
+	buf     strings.Builder
+	args    []interface{}
+	// err 第一个错误的条件
+	err error
+}
+
+func newSqlBuilder(dialect Dialect) *sqlBuilder {
+	if dialect == nil {
+		dialect = MysqlDialect
+	}
+	return &sqlBuilder{dialect: dialect}
+}
+
+func (this *sqlBuilder) write(strs ...string) {
+	for _, str := range strs {
+		this.buf.WriteString(str)
+	}
+}
+
+func (this *sqlBuilder) quote(name string) string {
+	return quoteIdent(this.dialect, name)
+}
+
+// value 写入参数占位符，子查询直接展开
+func (this *sqlBuilder) value(value interface{}) {
+	if sub, ok := value.(*SelectBuilder); ok {
+		this.write("(")
+		sub.build(this)
+		this.write(")")
+		return
+	}
+	this.write(this.dialect.Placeholder(len(this.args)))
+	this.args = append(this.args, value)
+}
+
+// raw 写入原始sql，其中的?替换为当前方言的占位符
+func (this *sqlBuilder) raw(sql string, args []interface{}) {
+	argIndex := 0
+	for {
+		pos := strings.IndexByte(sql, '?')
+		if pos < 0 || argIndex >= len(args) {
+			break
+		}
+		this.write(sql[:pos])
+		this.value(args[argIndex])
+		argIndex++
+		sql = sql[pos+1:]
+	}
+	this.write(sql)
+}
+
+func (this *sqlBuilder) String() string {
+	return this.buf.String()
+}
+
+// BuildCond 生成条件语句与参数，有误的条件（如不支持的运算符）生成为1=0，可通过CheckCond检查.
+func BuildCond(dialect Dialect, cond Cond) (string, []interface{}) {
+	b := newSqlBuilder(dialect)
+	cond.build(b)
+	return b.String(), b.args
+}
+
+// CheckCond 返回条件（包括*SelectBuilder）中第一个有误的条件的错误.
+func CheckCond(cond Cond) error {
+	b := newSqlBuilder(nil)
+	cond.build(b)
+	return b.err
+}
+
+// errCond 有误的条件，生成为1=0并记录错误
+type errCond struct {
+	err error
+}
+
+func (this *errCond) build(b *sqlBuilder) {
+	if b.err == nil {
+		b.err = this.err
+	}
+	b.write("1=0")
+}
+
+var compareOps = map[string]bool{
+	"=": true, "!=": true, "<>": true, ">": true, ">=": true, "<": true, "<=": true,
+	"like": true, "not like": true,
+}
+
+type compareCond struct {
+	field string
+	op    string
+	value interface{}
+}
+
+func (this *compareCond) build(b *sqlBuilder) {
+	b.write(b.quote(this.field), " ", this.op, " ")
+	b.value(this.value)
+}
+
+// Compare field op value，op可为=、!=、<>、>、>=、<、<=、like、not like，value可为*SelectBuilder.
+// 不支持的op返回有误的条件，执行时返回错误.
+func Compare(field string, op string, value interface{}) Cond {
+	op = strings.ToLower(strings.TrimSpace(op))
+	if !compareOps[op] {
+		return &errCond{err: fmt.Errorf("unsupported operator %q", op)}
+	}
+	return &compareCond{field: field, op: op, value: value}
+}
+
+// Eq field = value
+func Eq(field string, value interface{}) Cond { return Compare(field, "=", value) }
+
+// Neq field != value
+func Neq(field string, value interface{}) Cond { return Compare(field, "!=", value) }
+
+// Gt field > value
+func Gt(field string, value interface{}) Cond { return Compare(field, ">", value) }
+
+// Gte field >= value
+func Gte(field string, value interface{}) Cond { return Compare(field, ">=", value) }
+
+// Lt field < value
+func Lt(field string, value interface{}) Cond { return Compare(field, "<", value) }
+
+// Lte field <= value
+func Lte(field string, value interface{}) Cond { return Compare(field, "<=", value) }
+
+// Like field like pattern，pattern需自行包含%
+func Like(field string, pattern string) Cond { return Compare(field, "like", pattern) }
+
+// NotLike field not like pattern
+func NotLike(field string, pattern string) Cond { return Compare(field, "not like", pattern) }
+
+type inCond struct {
+	field  string
+	not    bool
+	values []interface{}
+	sub    *SelectBuilder
+}
+
+func (this *inCond) build(b *sqlBuilder) {
+	if this.sub == nil && len(this.values) == 0 {
+		// in ()为非法语句，空集合in恒为假，not in恒为真
+		if this.not {
+			b.write("1=1")
+		} else {
+			b.write("1=0")
+		}
+		return
+	}
+	b.write(b.quote(this.field))
+	if this.not {
+		b.write(" not")
+	}
+	b.write(" in ")
+	if this.sub != nil {
+		b.value(this.sub)
+		return
+	}
+	b.write("(")
+	for i, value := range this.values {
+		if i > 0 {
+			b.write(",")
+		}
+		b.value(value)
+	}
+	b.write(")")
+}
+
+func newInCond(field string, not bool, values []interface{}) Cond {
+	cond := &inCond{field: field, not: not}
+	if len(values) == 1 {
+		if sub, ok := values[0].(*SelectBuilder); ok {
+			cond.sub = sub
+			return cond
+		}
+		// 支持直接传入切片，如In("id", []int{1, 2})
+		if v := reflect.ValueOf(values[0]); v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+			values = make([]interface{}, v.Len())
+			for i := range values {
+				values[i] = v.Index(i).Interface()
+			}
+		}
+	}
+	cond.values = values
+	return cond
+}
+
+// In field in (values)，values可以是多个值、一个切片或一个*SelectBuilder子查询
+func In(field string, values ...interface{}) Cond { return newInCond(field, false, values) }
+
+// NotIn field not in (values)
+func NotIn(field string, values ...interface{}) Cond { return newInCond(field, true, values) }
+
+type betweenCond struct {
+	field    string
+	from, to interface{}
+}
+
+func (this *betweenCond) build(b *sqlBuilder) {
+	b.write(b.quote(this.field), " between ")
+	b.value(this.from)
+	b.write(" and ")
+	b.value(this.to)
+}
+
+// Between field between from and to
+func Between(field string, from interface{}, to interface{}) Cond {
+	return &betweenCond{field: field, from: from, to: to}
+}
+
+type nullCond struct {
+	field string
+	not   bool
+}
+
+func (this *nullCond) build(b *sqlBuilder) {
+	b.write(b.quote(this.field))
+	if this.not {
+		b.write(" is not null")
+	} else {
+		b.write(" is null")
+	}
+}
+
+// IsNull field is null
+func IsNull(field string) Cond { return &nullCond{field: field} }
+
+// IsNotNull field is not null
+func IsNotNull(field string) Cond { return &nullCond{field: field, not: true} }
+
+type listCond struct {
+	connector string
+	conds     []Cond
+}
+
+func (this *listCond) build(b *sqlBuilder) {
+	conds := make([]Cond, 0, len(this.conds))
+	for _, cond := range this.conds {
+		if cond != nil {
+			conds = append(conds, cond)
+		}
+	}
+	if len(conds) == 0 {
+		b.write("1=1")
+		return
+	}
+	for i, cond := range conds {
+		if i > 0 {
+			b.write(" ", this.connector, " ")
+		}
+		if needParen(cond) && len(conds) > 1 {
+			b.write("(")
+			cond.build(b)
+			b.write(")")
+			continue
+		}
+		cond.build(b)
+	}
+}
+
+// needParen 组合条件与原始sql条件与其他条件连接时需加括号
+func needParen(cond Cond) bool {
+	switch cond.(type) {
+	case *listCond, *exprCond:
+		return true
+	}
+	return false
+}
+
+// And 多个条件同时满足，nil条件会被忽略，没有条件时恒为真
+func And(conds ...Cond) Cond { return &listCond{connector: "and", conds: conds} }
+
+// Or 满足任一条件
+func Or(conds ...Cond) Cond {
+	if len(conds) == 0 {
+		return Expr("1=0")
+	}
+	return &listCond{connector: "or", conds: conds}
+}
+
+type notCond struct {
+	cond Cond
+}
+
+func (this *notCond) build(b *sqlBuilder) {
+	b.write("not (")
+	this.cond.build(b)
+	b.write(")")
+}
+
+// Not 条件取反
+func Not(cond Cond) Cond { return &notCond{cond: cond} }
+
+type existsCond struct {
+	not bool
+	sub *SelectBuilder
+}
+
+func (this *existsCond) build(b *sqlBuilder) {
+	if this.not {
+		b.write("not ")
+	}
+	b.write("exists ")
+	b.value(this.sub)
+}
+
+// Exists exists (sub)
+func Exists(sub *SelectBuilder) Cond { return &existsCond{sub: sub} }
+
+// NotExists not exists (sub)
+func NotExists(sub *SelectBuilder) Cond { return &existsCond{not: true, sub: sub} }
+
+type exprCond struct {
+	sql  string
+	args []interface{}
+}
+
+func (this *exprCond) build(b *sqlBuilder) {
+	b.raw(this.sql, this.args)
+}
+
+// Expr 原始sql条件，参数使用?占位，会按方言替换，如Expr("`a` + `b` > ?", 10)
+func Expr(sql string, args ...interface{}) Cond { return &exprCond{sql: sql, args: args} }
+
+type joinItem struct {
+	kind  string
+	table string
+	on    Cond
+}
+
+// SelectBuilder select语句，条件按添加顺序生成，相同的输入总是生成相同的sql
+// e.g.
+//
+//	sql, args := model.Select("u.id", "u.name", "count(o.id) as cnt").From("user u").
+//		LeftJoin("order o", model.Expr("o.userId = u.id")).
+//		Where(model.Eq("u.isDeleted", 0), model.Or(model.In("u.type", 1, 2), model.Like("u.name", "%a%"))).
+//		GroupBy("u.id").Having(model.Gt("cnt", 1)).
+//		OrderBy("u.id desc").Limit(20).Offset(40).
+//		ToSql(model.MysqlDialect)
+type SelectBuilder struct {
+	distinct bool
+	fields   []string
+	table    string
+	from     *SelectBuilder
+	fromAs   string
+	joins    []joinItem
+	where    []Cond
+	groupBy  []string
+	having   []Cond
+	orderBy  []string
+	limit    int
+	offset   int
+}
+
+// Select 创建select语句，fields为空时查询*
+func Select(fields ...string) *SelectBuilder {
+	return &SelectBuilder{fields: fields}
+}
+
+// Distinct select distinct
+func (this *SelectBuilder) Distinct() *SelectBuilder {
+	this.distinct = true
+	return this
+}
+
+// Fields 替换查询的字段
+func (this *SelectBuilder) Fields(fields ...string) *SelectBuilder {
+	this.fields = fields
+	return this
+}
+
+// From 表名，可带别名，如"user u"
+func (this *SelectBuilder) From(table string) *SelectBuilder {
+	this.table = table
+	this.from = nil
+	return this
+}
+
+// FromQuery 从子查询中查询
+func (this *SelectBuilder) FromQuery(sub *SelectBuilder, alias string) *SelectBuilder {
+	this.from = sub
+	this.fromAs = alias
+	return this
+}
+
+// Join 连表，kind为join、left join、right join、inner join等
+func (this *SelectBuilder) Join(kind string, table string, on Cond) *SelectBuilder {
+	this.joins = append(this.joins, joinItem{kind: kind, table: table, on: on})
+	return this
+}
+
+// LeftJoin left join
+func (this *SelectBuilder) LeftJoin(table string, on Cond) *SelectBuilder {
+	return this.Join("left join", table, on)
+}
+
+// InnerJoin inner join
+func (this *SelectBuilder) InnerJoin(table string, on Cond) *SelectBuilder {
+	return this.Join("inner join", table, on)
+}
+
+// Where 添加条件，多次调用时以and连接
+func (this *SelectBuilder) Where(conds ...Cond) *SelectBuilder {
+	this.where = append(this.where, conds...)
+	return this
+}
+
+// GroupBy group by
+func (this *SelectBuilder) GroupBy(fields ...string) *SelectBuilder {
+	this.groupBy = append(this.groupBy, fields...)
+	return this
+}
+
+// Having having，多次调用时以and连接
+func (this *SelectBuilder) Having(conds ...Cond) *SelectBuilder {
+	this.having = append(this.having, conds...)
+	return this
+}
+
+// OrderBy 排序，如"id desc"
+func (this *SelectBuilder) OrderBy(orders ...string) *SelectBuilder {
+	this.orderBy = append(this.orderBy, orders...)
+	return this
+}
+
+// Limit limit，小于等于0时不限制
+func (this *SelectBuilder) Limit(limit int) *SelectBuilder {
+	this.limit = limit
+	return this
+}
+
+// Offset offset
+func (this *SelectBuilder) Offset(offset int) *SelectBuilder {
+	this.offset = offset
+	return this
+}
+
+// Clone 复制一份语句，修改副本不影响原语句
+func (this *SelectBuilder) Clone() *SelectBuilder {
+	clone := *this
+	clone.fields = append([]string(nil), this.fields...)
+	clone.joins = append([]joinItem(nil), this.joins...)
+	clone.where = append([]Cond(nil), this.where...)
+	clone.groupBy = append([]string(nil), this.groupBy...)
+	clone.having = append([]Cond(nil), this.having...)
+	clone.orderBy = append([]string(nil), this.orderBy...)
+	return &clone
+}
+
+// Count 生成统计总数的语句，忽略排序与分页，包含group by或distinct时以子查询统计
+func (this *SelectBuilder) Count() *SelectBuilder {
+	clone := this.Clone()
+	clone.orderBy = nil
+	clone.limit = 0
+	clone.offset = 0
+	if clone.distinct || len(clone.groupBy) > 0 {
+		return Select("count(*)").FromQuery(clone, "t")
+	}
+	clone.fields = []string{"count(*)"}
+	return clone
+}
+
+// ToSql 生成sql与参数，有误的条件生成为1=0，可通过CheckCond检查
+func (this *SelectBuilder) ToSql(dialect Dialect) (string, []interface{}) {
+	b := newSqlBuilder(dialect)
+	this.build(b)
+	return b.String(), b.args
+}
+
+func (this *SelectBuilder) build(b *sqlBuilder) {
+	b.write("select ")
+	if this.distinct {
+		b.write("distinct ")
+	}
+	if len(this.fields) == 0 {
+		b.write("*")
+	}
+	for i, field := range this.fields {
+		if i > 0 {
+			b.write(",")
+		}
+		b.write(b.quote(field))
+	}
+	if this.from != nil {
+		b.write(" from ")
+		b.value(this.from)
+		b.write(" ", b.quote(this.fromAs))
+	} else if len(this.table) > 0 {
+		b.write(" from ", quoteTable(b.dialect, this.table))
+	}
+	for _, join := range this.joins {
+		b.write(" ", join.kind, " ", quoteTable(b.dialect, join.table))
+		if join.on != nil {
+			b.write(" on ")
+			join.on.build(b)
+		}
+	}
+	if len(this.where) > 0 {
+		b.write(" where ")
+		And(this.where...).build(b)
+	}
+	if len(this.groupBy) > 0 {
+		b.write(" group by ")
+		for i, field := range this.groupBy {
+			if i > 0 {
+				b.write(",")
+			}
+			b.write(b.quote(field))
+		}
+	}
+	if len(this.having) > 0 {
+		b.write(" having ")
+		And(this.having...).build(b)
+	}
+	if len(this.orderBy) > 0 {
+		b.write(" order by ")
+		for i, order := range this.orderBy {
+			if i > 0 {
+				b.write(",")
+			}
+			b.write(quoteOrder(b.dialect, order))
+		}
+	}
+	if this.limit > 0 {
+		b.write(fmt.Sprintf(" limit %d", this.limit))
+		if this.offset > 0 {
+			b.write(fmt.Sprintf(" offset %d", this.offset))
+		}
+	}
+}
+
+// quoteOrder 转义排序字段，如"id desc"
+func quoteOrder(dialect Dialect, order string) string {
+	parts := strings.Fields(order)
+	if len(parts) == 2 {
+		direction := strings.ToLower(parts[1])
+		if direction == "asc" || direction == "desc" {
+			return quoteIdent(dialect, parts[0]) + " " + direction
+		}
+	}
+	return quoteIdent(dialect, order)
+}
@@ -0,0 +1,150 @@
+package model
+
+import (
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	gorp "gopkg.in/gorp.v1"
+)
+
+const (
+	defaultReplicaCheckInterval = 5 * time.Second
+	defaultReplicaFailThreshold = 2
+)
+
+// ReplicaOptions 从库配置
+type ReplicaOptions struct {
+	// 健康检查间隔，默认5s
+	CheckInterval time.Duration
+	// 连续检查失败多少次后摘除，默认2次，摘除后检查成功即恢复
+	FailThreshold int
+	// 健康检查，默认为Ping，可自行检查复制延迟等
+	HealthCheck func(db *sql.DB) error
+}
+
+type replica struct {
+	dbMap    *gorp.DbMap
+	healthy  int32
+	failures int
+}
+
+// ReplicaSet 一组从库，查询按轮询分配到健康的从库，没有健康的从库时由调用方回退到主库
+type ReplicaSet struct {
+	replicas []*replica
+	next     uint32
+	options  *ReplicaOptions
+	done     chan struct{}
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+// NewReplicaSet 创建从库组，检查一次所有从库后定时检查
+func NewReplicaSet(dbMaps []*gorp.DbMap, options *ReplicaOptions) *ReplicaSet {
+	if options == nil {
+		options = &ReplicaOptions{}
+	}
+	if options.CheckInterval <= 0 {
+		options.CheckInterval = defaultReplicaCheckInterval
+	}
+	if options.FailThreshold <= 0 {
+		options.FailThreshold = defaultReplicaFailThreshold
+	}
+	if options.HealthCheck == nil {
+		options.HealthCheck = func(db *sql.DB) error { return db.Ping() }
+	}
+	this := &ReplicaSet{options: options, done: make(chan struct{})}
+	for i, dbMap := range dbMaps {
+		r := &replica{dbMap: dbMap, healthy: 1}
+		// 启动时不可用的从库直接摘除
+		if err := options.HealthCheck(dbMap.Db); err != nil {
+			r.healthy = 0
+			logrus.WithError(err).WithField("replica", i).Error("replica ejected")
+		}
+		this.replicas = append(this.replicas, r)
+	}
+	this.wg.Add(1)
+	go this.check()
+	return this
+}
+
+// DbMaps 所有从库
+func (this *ReplicaSet) DbMaps() []*gorp.DbMap {
+	dbMaps := make([]*gorp.DbMap, 0, len(this.replicas))
+	for _, r := range this.replicas {
+		dbMaps = append(dbMaps, r.dbMap)
+	}
+	return dbMaps
+}
+
+// Healthy 健康的从库数量
+func (this *ReplicaSet) Healthy() int {
+	count := 0
+	for _, r := range this.replicas {
+		if atomic.LoadInt32(&r.healthy) == 1 {
+			count++
+		}
+	}
+	return count
+}
+
+// pick 轮询选择健康的从库，全部不健康时返回nil
+func (this *ReplicaSet) pick() *gorp.DbMap {
+	if this == nil || len(this.replicas) == 0 {
+		return nil
+	}
+	start := atomic.AddUint32(&this.next, 1)
+	for i := 0; i < len(this.replicas); i++ {
+		r := this.replicas[(int(start)+i)%len(this.replicas)]
+		if atomic.LoadInt32(&r.healthy) == 1 {
+			return r.dbMap
+		}
+	}
+	return nil
+}
+
+func (this *ReplicaSet) check() {
+	defer this.wg.Done()
+	ticker := time.NewTicker(this.options.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for i, r := range this.replicas {
+				this.checkReplica(i, r)
+			}
+		case <-this.done:
+			return
+		}
+	}
+}
+
+func (this *ReplicaSet) checkReplica(index int, r *replica) {
+	err := this.options.HealthCheck(r.dbMap.Db)
+	if err == nil {
+		r.failures = 0
+		if atomic.CompareAndSwapInt32(&r.healthy, 0, 1) {
+			logrus.WithField("replica", index).Info("replica recovered")
+		}
+		return
+	}
+	r.failures++
+	if r.failures >= this.options.FailThreshold && atomic.CompareAndSwapInt32(&r.healthy, 1, 0) {
+		logrus.WithError(err).WithField("replica", index).Error("replica ejected")
+	}
+}
+
+// Close 停止健康检查并关闭所有从库连接，重复调用时不再处理
+func (this *ReplicaSet) Close() {
+	this.once.Do(func() {
+		close(this.done)
+		this.wg.Wait()
+		for _, r := range this.replicas {
+			if err := r.dbMap.Db.Close(); err != nil {
+				logrus.Error(err.Error())
+			}
+		}
+	})
+}
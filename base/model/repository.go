@@ -0,0 +1,190 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrNotFound 记录不存在
+var ErrNotFound = errors.New("record not found")
+
+// commonModel Repository依赖的CommonModel方法，model需嵌入CommonModel
+type commonModel interface {
+	Model
+	Create(model interface{}) (int, error)
+	UpdateModel(model interface{}) (int, error)
+	UpdateById(fields map[string]interface{}, id ...int) (int, error)
+	DeleteById(id ...int) (int, error)
+	RestoreById(id ...int) (int, error)
+	HardDeleteById(id ...int) (int, error)
+	PurgeDeleted(conditions QueryMap) (int, error)
+	GetInterfaceById(dataType interface{}, id ...int) ([]interface{}, error)
+	SearchInterface(page int, pageSize int, sort string, query map[string]string, genCondition func(map[string]string) QueryMap, dataType interface{}) ([]interface{}, int, error)
+	SearchAllInterface(sort string, query map[string]string, genCondition func(map[string]string) QueryMap, dataType interface{}) ([]interface{}, error)
+	CreateBatch(models interface{}, chunkSize int) ([]int, error)
+	Upsert(models interface{}, conflictKeys []string, updateFields []string, chunkSize int) ([]int, error)
+	UpdateBatchById(rows map[int]map[string]interface{}, chunkSize int) ([]int, error)
+	SearchByCursor(cursor string, pageSize int, sort string, query map[string]string, genCondition func(map[string]string) QueryMap, dataType interface{}, countMode CountMode) ([]interface{}, *CursorPage, error)
+}
+
+// Repository 基于CommonModel的类型化查询，T为与model绑定的结构体，方法直接返回*T或[]*T
+// e.g.
+//
+//	userRepo := model.NewRepository[User](userModel)
+//	user, err := userRepo.GetByID(1)
+//	users, total, err := userRepo.Page(1, 20, "id desc", model.QueryMap{"status": model.QueryItem{"=", 1}})
+type Repository[T any] struct {
+	model commonModel
+}
+
+// NewRepository model为注册到mysql/sqlite模块的model，T为与其绑定的结构体
+func NewRepository[T any](model Model) *Repository[T] {
+	m, ok := model.(commonModel)
+	if !ok {
+		panic(fmt.Sprintf("model %T must embed CommonModel", model))
+	}
+	if objT := reflect.TypeOf((*T)(nil)).Elem(); objT.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("%v must be a struct", objT))
+	}
+	return &Repository[T]{model: m}
+}
+
+// Model 获取绑定的model
+func (this *Repository[T]) Model() Model {
+	return this.model
+}
+
+// WithTx 获取绑定到事务的Repository
+func (this *Repository[T]) WithTx(tx *Tx) *Repository[T] {
+	return &Repository[T]{model: tx.Model(this.model).(commonModel)}
+}
+
+// UsePrimary 获取强制读主库的Repository，用于写入后立即读取
+func (this *Repository[T]) UsePrimary() *Repository[T] {
+	return &Repository[T]{model: UsePrimary(this.model).(commonModel)}
+}
+
+// WithContext 获取绑定ctx的Repository，ctx取消或超时时中断执行中的语句
+func (this *Repository[T]) WithContext(ctx context.Context) *Repository[T] {
+	return &Repository[T]{model: WithContext(this.model, ctx).(commonModel)}
+}
+
+// WithDeleted 获取查询、更新包含已删除记录的Repository
+func (this *Repository[T]) WithDeleted() *Repository[T] {
+	return &Repository[T]{model: WithDeleted(this.model).(commonModel)}
+}
+
+// OnlyDeleted 获取只查询、更新已删除记录的Repository
+func (this *Repository[T]) OnlyDeleted() *Repository[T] {
+	return &Repository[T]{model: OnlyDeleted(this.model).(commonModel)}
+}
+
+// GetByID 通过id获取记录，不存在时返回ErrNotFound
+func (this *Repository[T]) GetByID(id int) (*T, error) {
+	list, err := this.GetByIDs(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrNotFound
+	}
+	return list[0], nil
+}
+
+// GetByIDs 通过id获取多条记录
+func (this *Repository[T]) GetByIDs(ids ...int) ([]*T, error) {
+	list := []*T{}
+	if len(ids) == 0 {
+		return list, nil
+	}
+	_, err := this.model.GetInterfaceById(&list, ids...)
+	return list, err
+}
+
+// List 按条件查询所有记录
+func (this *Repository[T]) List(sort string, conditions QueryMap) ([]*T, error) {
+	list := []*T{}
+	_, err := this.model.SearchAllInterface(sort, nil, genConditionOf(conditions), &list)
+	return list, err
+}
+
+// Page 按条件分页查询，返回记录与总数
+func (this *Repository[T]) Page(page int, pageSize int, sort string, conditions QueryMap) ([]*T, int, error) {
+	list := []*T{}
+	_, total, err := this.model.SearchInterface(page, pageSize, sort, nil, genConditionOf(conditions), &list)
+	return list, total, err
+}
+
+// PageByCursor 按条件游标分页查询，cursor为上一页返回的NextCursor，第一页传空
+func (this *Repository[T]) PageByCursor(cursor string, pageSize int, sort string, conditions QueryMap, countMode CountMode) ([]*T, *CursorPage, error) {
+	list := []*T{}
+	_, page, err := this.model.SearchByCursor(cursor, pageSize, sort, nil, genConditionOf(conditions), &list, countMode)
+	return list, page, err
+}
+
+// Create 插入记录，返回id
+func (this *Repository[T]) Create(obj *T) (int, error) {
+	return this.model.Create(obj)
+}
+
+// CreateBatch 批量插入，返回与objs顺序一致的id
+func (this *Repository[T]) CreateBatch(objs []*T, chunkSize int) ([]int, error) {
+	return this.model.CreateBatch(objs, chunkSize)
+}
+
+// Upsert 批量插入，conflictKeys冲突时更新updateFields，返回与objs顺序一致的id
+func (this *Repository[T]) Upsert(objs []*T, conflictKeys []string, updateFields []string, chunkSize int) ([]int, error) {
+	return this.model.Upsert(objs, conflictKeys, updateFields, chunkSize)
+}
+
+// UpdateBatch 按id批量更新，rows的key为id，value为该行要更新的字段，返回存在且未删除的id
+func (this *Repository[T]) UpdateBatch(rows map[int]map[string]interface{}, chunkSize int) ([]int, error) {
+	return this.model.UpdateBatchById(rows, chunkSize)
+}
+
+// Update 按主键更新整条记录，嵌入VersionModel时版本号不一致返回VersionConflictError
+func (this *Repository[T]) Update(obj *T) (int, error) {
+	return this.model.UpdateModel(obj)
+}
+
+// UpdateFields 按id更新指定字段，嵌入VersionModel时fields中的version作为期望的版本号
+func (this *Repository[T]) UpdateFields(fields map[string]interface{}, ids ...int) (int, error) {
+	return this.model.UpdateById(fields, ids...)
+}
+
+// SoftDelete 软删除，将isDeleted置为1，model未启用软删除时返回ErrSoftDeleteDisabled
+func (this *Repository[T]) SoftDelete(ids ...int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return this.model.DeleteById(ids...)
+}
+
+// Restore 恢复软删除的记录
+func (this *Repository[T]) Restore(ids ...int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return this.model.RestoreById(ids...)
+}
+
+// HardDelete 物理删除
+func (this *Repository[T]) HardDelete(ids ...int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return this.model.HardDeleteById(ids...)
+}
+
+// Purge 物理删除满足条件的已软删除记录
+func (this *Repository[T]) Purge(conditions QueryMap) (int, error) {
+	return this.model.PurgeDeleted(conditions)
+}
+
+func genConditionOf(conditions QueryMap) func(map[string]string) QueryMap {
+	return func(map[string]string) QueryMap {
+		return conditions
+	}
+}
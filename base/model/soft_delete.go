@@ -0,0 +1,143 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrSoftDeleteDisabled model未启用软删除（字段中没有isDeleted或调用了SetSoftDelete(false)）
+var ErrSoftDeleteDisabled = errors.New("soft delete is not enabled for this model")
+
+// DeletedTimeModel 与BaseModel、VersionModel一起嵌入，软删除时记录删除时间，恢复时置为null
+// e.g.
+//
+//	type User struct {
+//		model.BaseModel
+//		model.DeletedTimeModel
+//		Name string `db:"name"`
+//	}
+type DeletedTimeModel struct {
+	DeletedTime *time.Time `db:"deletedTime" json:"deletedTime"`
+}
+
+// deleteScope 软删除的查询范围
+type deleteScope int
+
+const (
+	// scopeNotDeleted 只查询未删除的记录
+	scopeNotDeleted deleteScope = iota
+	// scopeWithDeleted 查询所有记录
+	scopeWithDeleted
+	// scopeOnlyDeleted 只查询已删除的记录
+	scopeOnlyDeleted
+)
+
+// WithDeleted 获取查询、更新包含已删除记录的model副本.
+// e.g.
+//
+//	users := model.WithDeleted(userModel).(*UserModel)
+func WithDeleted(model Model) Model {
+	view := cloneModel(model)
+	view.(commonGetter).common().scope = scopeWithDeleted
+	return view
+}
+
+// OnlyDeleted 获取只查询、更新已删除记录的model副本.
+func OnlyDeleted(model Model) Model {
+	view := cloneModel(model)
+	view.(commonGetter).common().scope = scopeOnlyDeleted
+	return view
+}
+
+// SetSoftDelete 显式启用或关闭软删除，未调用时字段中包含isDeleted即启用，表中有isDeleted但需要物理删除时调用SetSoftDelete(false).
+// 启用后查询、更新只作用于未删除的记录，DeleteById将isDeleted置为1；未启用时DeleteById、RestoreById、PurgeDeleted返回ErrSoftDeleteDisabled.
+// e.g.
+//
+//	logModel.SetSoftDelete(false)
+func (this *CommonModel) SetSoftDelete(enable bool) {
+	this.softDelete = enable
+	this.softDeleteSet = true
+}
+
+// SoftDeleteEnabled 是否启用软删除.
+func (this *CommonModel) SoftDeleteEnabled() bool {
+	return this.softDelete
+}
+
+// deletedCond 软删除范围的条件，未启用软删除或包含已删除记录时为nil
+func (this *CommonModel) deletedCond() Cond {
+	if !this.softDelete {
+		return nil
+	}
+	switch this.scope {
+	case scopeWithDeleted:
+		return nil
+	case scopeOnlyDeleted:
+		return Eq("isDeleted", 1)
+	}
+	return Eq("isDeleted", 0)
+}
+
+// RestoreById 恢复软删除的记录，有deletedTime时置为null.
+func (this *CommonModel) RestoreById(id ...int) (int, error) {
+	if !this.softDelete {
+		return 0, ErrSoftDeleteDisabled
+	}
+	fields := map[string]interface{}{"isDeleted": 0}
+	if this.hasDeletedTime {
+		fields["deletedTime"] = nil
+	}
+	return this.updateRows(AuditRestore, fields, And(In("id", id), Eq("isDeleted", 1)))
+}
+
+// HardDeleteById 按id物理删除，不区分是否已软删除.
+func (this *CommonModel) HardDeleteById(id ...int) (int, error) {
+	return this.hardDelete(In("id", id))
+}
+
+// PurgeDeleted 物理删除满足条件的已软删除记录，如清理30天前删除的记录.
+// e.g.
+//
+//	userModel.PurgeDeleted(model.QueryMap{"deletedTime": model.QueryItem{"<", time.Now().AddDate(0, 0, -30)}})
+func (this *CommonModel) PurgeDeleted(conditions QueryMap) (int, error) {
+	if !this.softDelete {
+		return 0, ErrSoftDeleteDisabled
+	}
+	return this.hardDelete(And(Eq("isDeleted", 1), conditions.Cond()))
+}
+
+func (this *CommonModel) hardDelete(cond Cond) (int, error) {
+	if err := CheckCond(cond); err != nil {
+		return 0, err
+	}
+	return this.auditTx(func(c *CommonModel) (int, error) {
+		before, err := c.auditRows(cond)
+		if err != nil {
+			return 0, err
+		}
+		ids, err := c.cacheIds(cond)
+		if err != nil {
+			return 0, err
+		}
+		b := newSqlBuilder(c.Dialect())
+		b.write("delete from ", quoteTable(b.dialect, c.GetModel().GetTable()), " where ")
+		cond.build(b)
+		rows, err := c.exec(b.String(), b.args...)
+		if err != nil || rows == 0 {
+			return rows, err
+		}
+		c.InvalidateCache(ids...)
+		return rows, c.auditDeleted(before)
+	})
+}
+
+// hasField fields中是否包含field，fields为SetFields设置的转义后的字段
+func hasField(fields string, field string) bool {
+	for _, item := range strings.Split(fields, ",") {
+		if strings.EqualFold(strings.Trim(strings.TrimSpace(item), "`\""), field) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	gorp "gopkg.in/gorp.v1"
+)
+
+type softDeleteUser struct {
+	BaseModel
+	Name string `db:"name"`
+}
+
+type softDeleteUserModel struct {
+	CommonModel
+}
+
+func (this *softDeleteUserModel) GetTable() string {
+	return "user"
+}
+
+func newSoftDeleteUserModel(t *testing.T) *softDeleteUserModel {
+	db, err := OpenDB("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	dbMap := &gorp.DbMap{Db: db, Dialect: gorp.SqliteDialect{}}
+	m := &softDeleteUserModel{}
+	m.SetDbMap(dbMap)
+	m.SetModel(m)
+	if err = m.Initer(dbMap, softDeleteUser{}, "user"); err != nil {
+		t.Fatal(err)
+	}
+	m.SetFields(GetAllFieldsAsString(softDeleteUser{}, SqliteDialect))
+	if err = dbMap.CreateTablesIfNotExists(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err = m.Create(&softDeleteUser{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+func TestBaseModelHidesDeletedRows(t *testing.T) {
+	m := newSoftDeleteUserModel(t)
+	if !m.SoftDeleteEnabled() {
+		t.Fatal("soft delete should be enabled by isDeleted")
+	}
+	if n, err := m.DeleteById(1); n != 1 || err != nil {
+		t.Fatalf("delete: %v %v", n, err)
+	}
+	if list, err := m.GetInterfaceById(softDeleteUser{}, 1, 2); err != nil || len(list) != 1 {
+		t.Fatalf("get: %v %v", list, err)
+	}
+	var users []*softDeleteUser
+	if _, total, err := m.SearchInterface(1, 10, "", nil, nil, &users); err != nil || total != 1 || len(users) != 1 || users[0].Id != 2 {
+		t.Fatalf("search: %v %v %v", total, users, err)
+	}
+	if n, err := m.UpdateById(map[string]interface{}{"name": "x"}, 1); n != 0 || err != nil {
+		t.Fatalf("update deleted: %v %v", n, err)
+	}
+	if n, err := m.HardDeleteById(1); n != 1 || err != nil {
+		t.Fatalf("hard delete: %v %v", n, err)
+	}
+}
+
+func TestSoftDeleteOptOut(t *testing.T) {
+	m := newSoftDeleteUserModel(t)
+	m.SetSoftDelete(false)
+	m.SetFields(m.GetFields())
+	if m.SoftDeleteEnabled() {
+		t.Fatal("SetSoftDelete(false) should survive SetFields")
+	}
+	if _, err := m.DeleteById(1); err != ErrSoftDeleteDisabled {
+		t.Fatalf("delete: %v", err)
+	}
+	if list, err := m.GetInterfaceById(softDeleteUser{}, 1); err != nil || len(list) != 1 {
+		t.Fatalf("get: %v %v", list, err)
+	}
+}
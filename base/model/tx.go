@@ -0,0 +1,189 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	gorp "gopkg.in/gorp.v1"
+)
+
+const defaultTxRetryInterval = 100 * time.Millisecond
+
+// TxOptions 事务选项
+type TxOptions struct {
+	// 出现死锁等可重试的错误时，整个事务的重试次数，默认不重试
+	Retries int
+	// 重试间隔，按重试次数递增，默认100ms
+	RetryInterval time.Duration
+	// 判断错误是否可重试，默认为IsDeadlock
+	Retryable func(err error) bool
+}
+
+// Tx 事务，通过Model获取绑定到该事务的model
+type Tx struct {
+	*gorp.Transaction
+	dbMap *gorp.DbMap
+	ctx   context.Context
+	// 事务使用的连接，语句执行时替换为model的ctx
+	conn  *contextConn
+	depth int
+	// 提交后执行，如失效缓存
+	committed []func()
+}
+
+// Transaction 在事务中执行fn，fn返回错误或panic时回滚，否则提交
+// fn中需通过tx.Model获取绑定到事务的model，fn可能因重试被多次调用
+// e.g.
+//
+//	err := model.Transaction(userModel.DbMap(), func(tx *model.Tx) error {
+//		user := tx.Model(userModel).(*UserModel)
+//		log := tx.Model(logModel).(*LogModel)
+//		...
+//	}, &model.TxOptions{Retries: 3})
+func Transaction(dbMap *gorp.DbMap, fn func(tx *Tx) error, options ...*TxOptions) error {
+	return TransactionContext(context.Background(), dbMap, fn, options...)
+}
+
+// TransactionContext 同Transaction，ctx取消或超时时中断执行中的语句并回滚.
+// 事务中绑定了context或设置了超时的model，语句使用model的ctx与超时.
+func TransactionContext(ctx context.Context, dbMap *gorp.DbMap, fn func(tx *Tx) error, options ...*TxOptions) error {
+	option := &TxOptions{}
+	if len(options) > 0 && options[0] != nil {
+		option = options[0]
+	}
+	if option.RetryInterval <= 0 {
+		option.RetryInterval = defaultTxRetryInterval
+	}
+	if option.Retryable == nil {
+		option.Retryable = IsDeadlock
+	}
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, dbMap, fn)
+		if err == nil || attempt >= option.Retries || ctx.Err() != nil || !option.Retryable(err) {
+			return err
+		}
+		logrus.WithError(err).WithField("attempt", attempt+1).Warn("retry transaction")
+		time.Sleep(option.RetryInterval * time.Duration(attempt+1))
+	}
+}
+
+func runTx(ctx context.Context, dbMap *gorp.DbMap, fn func(tx *Tx) error) error {
+	var tx *Tx
+	err := withConn(ctx, dbMap, func(m *gorp.DbMap, conn *contextConn) error {
+		t, err := m.Begin()
+		if err != nil {
+			return err
+		}
+		tx = &Tx{Transaction: t, dbMap: dbMap, ctx: ctx, conn: conn}
+		defer func() {
+			if p := recover(); p != nil {
+				if rbErr := t.Rollback(); rbErr != nil {
+					logrus.WithError(rbErr).Error("rollback transaction failed")
+				}
+				panic(p)
+			}
+		}()
+		if err = fn(tx); err != nil {
+			if rbErr := t.Rollback(); rbErr != nil {
+				logrus.WithError(rbErr).Error("rollback transaction failed")
+			}
+			return err
+		}
+		return t.Commit()
+	})
+	if err != nil {
+		return err
+	}
+	for _, fn := range tx.committed {
+		fn()
+	}
+	return nil
+}
+
+// Nested 嵌套事务，使用savepoint实现，fn返回错误或panic时只回滚到savepoint
+func (this *Tx) Nested(fn func(tx *Tx) error) (err error) {
+	this.depth++
+	defer func() { this.depth-- }()
+	savepoint := fmt.Sprintf("sp_%d", this.depth)
+	if err = this.Savepoint(savepoint); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			if rbErr := this.RollbackToSavepoint(savepoint); rbErr != nil {
+				logrus.WithError(rbErr).Error("rollback to savepoint failed")
+			}
+			panic(p)
+		}
+	}()
+	if err = fn(this); err != nil {
+		if rbErr := this.RollbackToSavepoint(savepoint); rbErr != nil {
+			logrus.WithError(rbErr).Error("rollback to savepoint failed")
+		}
+		return err
+	}
+	return this.ReleaseSavepoint(savepoint)
+}
+
+// Insert 同gorp.Transaction.Insert，启用审计的结构体返回ErrAuditBypass
+func (this *Tx) Insert(list ...interface{}) error {
+	if err := checkAuditBypass(this.dbMap, list); err != nil {
+		return err
+	}
+	return this.Transaction.Insert(list...)
+}
+
+// Update 同gorp.Transaction.Update，启用审计的结构体返回ErrAuditBypass
+func (this *Tx) Update(list ...interface{}) (int64, error) {
+	if err := checkAuditBypass(this.dbMap, list); err != nil {
+		return 0, err
+	}
+	return this.Transaction.Update(list...)
+}
+
+// Delete 同gorp.Transaction.Delete，启用审计的结构体返回ErrAuditBypass
+func (this *Tx) Delete(list ...interface{}) (int64, error) {
+	if err := checkAuditBypass(this.dbMap, list); err != nil {
+		return 0, err
+	}
+	return this.Transaction.Delete(list...)
+}
+
+// afterCommit 事务提交后执行fn，回滚时不执行
+func (this *Tx) afterCommit(fn func()) {
+	this.committed = append(this.committed, fn)
+}
+
+// Model 获取绑定到该事务的model副本，model需嵌入CommonModel且与事务属于同一个数据库
+func (this *Tx) Model(model Model) Model {
+	if model.DbMap() != this.dbMap {
+		panic(fmt.Sprintf("model %T belongs to another database", model))
+	}
+	view := cloneModel(model)
+	view.(commonGetter).common().tx = this
+	return view
+}
+
+// IsDeadlock 是否为死锁或锁等待超时等可重试的错误
+func IsDeadlock(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	for _, keyword := range deadlockKeywords {
+		if strings.Contains(msg, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+var deadlockKeywords = []string{
+	"Error 1213", // mysql ER_LOCK_DEADLOCK
+	"Error 1205", // mysql ER_LOCK_WAIT_TIMEOUT
+	"database is locked",
+	"deadlock detected",
+}
@@ -0,0 +1,99 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	gorp "gopkg.in/gorp.v1"
+)
+
+// VersionConflictError 乐观锁冲突，记录已被其他人修改或已不存在
+type VersionConflictError struct {
+	Table string
+	// 更新的id，按条件更新时为空
+	Keys []interface{}
+	// 期望的版本号
+	Version int64
+	// 记录是否存在，不存在时可能已被删除
+	RowExists bool
+}
+
+func (this *VersionConflictError) Error() string {
+	if this.RowExists {
+		return fmt.Sprintf("version conflict: table=%v keys=%v version=%v is out of date", this.Table, this.Keys, this.Version)
+	}
+	return fmt.Sprintf("version conflict: table=%v keys=%v row not found", this.Table, this.Keys)
+}
+
+// IsVersionConflict 是否为乐观锁冲突，包括gorp的OptimisticLockError
+func IsVersionConflict(err error) bool {
+	var conflict *VersionConflictError
+	if errors.As(err, &conflict) {
+		return true
+	}
+	var lockErr gorp.OptimisticLockError
+	return errors.As(err, &lockErr)
+}
+
+// versioned 嵌入VersionModel的结构体实现该接口
+type versioned interface {
+	version() int64
+}
+
+func (this *VersionModel) version() int64 {
+	return this.Version
+}
+
+func isVersioned(obj interface{}) bool {
+	objT := reflect.TypeOf(obj)
+	if objT.Kind() == reflect.Ptr {
+		objT = objT.Elem()
+	}
+	_, ok := reflect.New(objT).Interface().(versioned)
+	return ok
+}
+
+// versionConflictOf 将gorp的OptimisticLockError转换为VersionConflictError
+func versionConflictOf(err error) error {
+	if lockErr, ok := err.(gorp.OptimisticLockError); ok {
+		return &VersionConflictError{Table: lockErr.TableName, Keys: lockErr.Keys, Version: lockErr.LocalVersion, RowExists: lockErr.RowExists}
+	}
+	return err
+}
+
+// versionConflict 按版本号更新没有记录被更新时的错误，有keys时检查记录是否存在
+func (this *CommonModel) versionConflict(version interface{}, keys ...interface{}) error {
+	conflict := &VersionConflictError{Table: this.GetModel().GetTable(), Keys: keys, Version: toInt64(version), RowExists: true}
+	if len(keys) > 0 {
+		sql, params := Select("count(*)").From(this.GetModel().GetTable()).Where(In("id", keys...), this.deletedCond()).ToSql(this.Dialect())
+		count, err := this.executor().SelectInt(sql, params...)
+		if err != nil {
+			return err
+		}
+		conflict.RowExists = count > 0
+	}
+	return conflict
+}
+
+// withoutField 复制fields并去掉field
+func withoutField(fields map[string]interface{}, field string) map[string]interface{} {
+	copied := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		if key != field {
+			copied[key] = value
+		}
+	}
+	return copied
+}
+
+func toInt64(value interface{}) int64 {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	}
+	return 0
+}
@@ -33,6 +33,15 @@ type Config struct {
 	AccessLog bool
 
 	SaveOperation SaveOperation
+
+	// 非必需，接口缓存的默认存储，为空时使用进程内LRU缓存
+	CacheStore CacheStore
+
+	// 非必需，上传文件的存储，为空时保存到本地upload目录
+	UploadStorage UploadStorage
+
+	// 非必需，multipart请求体的最大字节数，0为不限制，单个文件的限制在prototype的upload tag中配置
+	MaxUploadSize int64
 }
 
 type ApiModule struct {
@@ -42,6 +51,8 @@ type ApiModule struct {
 	server        *http.Server
 	handlers      map[string]*httpHandler
 	sensitiveKeys []string
+	cacheStores   []CacheStore
+	sseHubs       []*SSEHub
 }
 
 var apiModule = New()
@@ -77,6 +88,41 @@ func RegisterHandler(method string, path string,
 	apiModule.RegisterHandler(method, path, handler, prototype)
 }
 
+// RegisterCacheHandler 注册带响应缓存的接口，仅对GET请求生效
+func (this *ApiModule) RegisterCacheHandler(method string, path string,
+	handler func(*gin.Context, interface{}) (interface{}, error),
+	prototype interface{}, option *CacheOption) {
+	this.RegisterHandler(method, path, handler, prototype)
+	this.handlers[method+":"+path].cache = option
+}
+
+func RegisterCacheHandler(method string, path string,
+	handler func(*gin.Context, interface{}) (interface{}, error),
+	prototype interface{}, option *CacheOption) {
+	apiModule.RegisterCacheHandler(method, path, handler, prototype, option)
+}
+
+// InvalidateCache 按标签失效缓存，如在UpdateById之后调用
+func (this *ApiModule) InvalidateCache(tags ...string) error {
+	stores := map[CacheStore]struct{}{}
+	if this.config != nil && this.config.CacheStore != nil {
+		stores[this.config.CacheStore] = struct{}{}
+	}
+	for _, store := range this.cacheStores {
+		stores[store] = struct{}{}
+	}
+	for store := range stores {
+		if err := store.InvalidateTags(tags...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func InvalidateCache(tags ...string) error {
+	return apiModule.InvalidateCache(tags...)
+}
+
 // SetSensitiveKeys SetSensitiveKeys
 func (this *ApiModule) SetSensitiveKeys(keys []string) {
 	this.sensitiveKeys = append(this.sensitiveKeys, keys...)
@@ -113,6 +159,30 @@ func (this *ApiModule) Init() error {
 		g.JSON(http.StatusNotFound, Output{Code: 404, Message: NOT_FOUND})
 	})
 
+	for _, handler := range this.handlers {
+		uploadFields, err := parseUploadFields(handler.prototype)
+		if err != nil {
+			return err
+		}
+		if len(uploadFields) > 0 && this.config.UploadStorage == nil {
+			this.config.UploadStorage = NewLocalStorage(defaultUploadDir)
+		}
+		handler.uploadFields = uploadFields
+		if handler.cache == nil {
+			continue
+		}
+		if handler.cache.TTL <= 0 {
+			handler.cache.TTL = defaultCacheTTL
+		}
+		if handler.cache.Store == nil {
+			if this.config.CacheStore == nil {
+				this.config.CacheStore = NewMemoryCache(defaultCacheCapacity)
+			}
+			handler.cache.Store = this.config.CacheStore
+		}
+		this.cacheStores = append(this.cacheStores, handler.cache.Store)
+	}
+
 	for prefix, filters := range this.config.GroupFilter {
 		group := this.config.Gin.Group(prefix)
 		for _, filter := range filters {
@@ -151,6 +221,10 @@ func (this *ApiModule) Run() error {
 }
 
 func (this *ApiModule) Stop() {
+	// SSE为长连接，先断开，避免Shutdown等待超时
+	for _, hub := range this.sseHubs {
+		hub.Close()
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 	this.server.Shutdown(ctx)
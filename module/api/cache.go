@@ -0,0 +1,83 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sayuri567/tool/module/redispool"
+	"github.com/sayuri567/tool/util/cache"
+)
+
+const (
+	defaultCacheCapacity = 1024
+	defaultCacheTTL      = time.Minute
+)
+
+// CacheStore 接口响应缓存的存储后端，与model的缓存共用cache.Store
+type CacheStore = cache.Store
+
+// CacheOption 单个接口的缓存配置
+type CacheOption struct {
+	// 缓存时间
+	TTL time.Duration
+
+	// 缓存标签，用于业务代码中通过InvalidateCache主动失效缓存
+	Tags []string
+
+	// 非必需，返回用户维度的key，如用户id，返回值会参与缓存key的计算
+	UserKey func(*gin.Context) string
+
+	// 非必需，响应中的Cache-Control头，为空时根据TTL与UserKey自动生成，为"-"时不输出
+	CacheControl string
+
+	// 非必需，为空时使用Config.CacheStore
+	Store CacheStore
+}
+
+func (this *CacheOption) cacheControl() string {
+	if this.CacheControl == "-" {
+		return ""
+	}
+	if len(this.CacheControl) > 0 {
+		return this.CacheControl
+	}
+	scope := "public"
+	if this.UserKey != nil {
+		scope = "private"
+	}
+	return fmt.Sprintf("%v, max-age=%d", scope, int(this.TTL/time.Second))
+}
+
+// genCacheKey 根据请求方法、路径、按参数名排序的query、绑定的参数以及用户key生成缓存key
+func genCacheKey(c *gin.Context, option *CacheOption, param interface{}) string {
+	paramBytes, _ := json.Marshal(param)
+	userKey := ""
+	if option.UserKey != nil {
+		userKey = option.UserKey(c)
+	}
+	// Encode按参数名排序，没有参数原型的接口也能区分不同的query
+	query := c.Request.URL.Query().Encode()
+	sum := sha1.Sum([]byte(strings.Join([]string{c.Request.Method, c.Request.URL.Path, query, string(paramBytes), userKey}, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewMemoryCache 创建进程内的LRU缓存，capacity为最大缓存条数
+func NewMemoryCache(capacity int) *cache.MemoryStore {
+	if capacity < 1 {
+		capacity = defaultCacheCapacity
+	}
+	return cache.NewMemoryStore(capacity)
+}
+
+// NewRedisCache poolName为redispool中注册的名称，为空时使用默认连接池，prefix默认为"api:cache:"
+func NewRedisCache(poolName string, prefix string) *redispool.Cache {
+	if len(prefix) == 0 {
+		prefix = "api:cache:"
+	}
+	return redispool.NewCache(poolName, prefix)
+}
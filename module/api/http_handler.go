@@ -1,11 +1,14 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"reflect"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sayuri567/tool/module/logger"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,6 +18,9 @@ type httpHandler struct {
 	handler   func(*gin.Context, interface{}) (interface{}, error)
 	prototype interface{} //接口参数
 	module    *ApiModule
+	cache     *CacheOption
+
+	uploadFields map[string]*uploadField
 }
 
 type SaveOperation interface {
@@ -26,16 +32,34 @@ func (this *httpHandler) ServeHTTP(c *gin.Context) {
 	var logDatas map[string]interface{}
 	var err error
 	var param interface{}
+	// 上传的文件只在handler成功时保留
+	var files map[string][]*UploadFile
+	kept := false
+	defer func() {
+		if !kept && len(files) > 0 {
+			this.deleteUploads(c, files)
+		}
+	}()
+	entry := this.newLogger(c)
 	if this.prototype != nil {
 		param = reflect.New(reflect.TypeOf(this.prototype).Elem()).Interface()
-		err = c.Bind(param)
+		if len(this.uploadFields) > 0 && c.ContentType() == gin.MIMEMultipartPOSTForm {
+			files, err = this.parseUpload(c)
+			if err == nil {
+				if err = c.Bind(param); err == nil {
+					this.setUploads(param, files)
+				}
+			}
+		} else {
+			err = c.Bind(param)
+		}
 		if this.module.config.AccessLog || this.module.config.SaveOperation != nil {
 			logDatas = this.getData(param)
 		}
 	}
 
 	if this.module.config.AccessLog {
-		logrus.WithFields(logrus.Fields{"uri": c.Request.RequestURI, "@type": "access", "params": logDatas, "method": c.Request.Method, "ip": c.ClientIP()}).Info("access log")
+		entry.WithFields(logrus.Fields{"@type": "access", "params": logDatas}).Info("access log")
 	}
 	if this.module.config.SaveOperation != nil {
 		this.module.config.SaveOperation.Save(c.Request.Method, c.Request.RequestURI, c.ClientIP(), logDatas, c.Keys)
@@ -45,6 +69,11 @@ func (this *httpHandler) ServeHTTP(c *gin.Context) {
 		return
 	}
 
+	if this.cache != nil && c.Request.Method == http.MethodGet {
+		kept = this.serveCache(c, param)
+		return
+	}
+
 	data, err := this.handler(c, param)
 	if c.IsAborted() {
 		return
@@ -56,9 +85,67 @@ func (this *httpHandler) ServeHTTP(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, output)
 		return
 	}
+	kept = true
 	c.JSON(http.StatusOK, output)
 }
 
+// serveCache 命中缓存时直接返回，否则执行handler并缓存成功的响应，返回handler是否执行成功
+func (this *httpHandler) serveCache(c *gin.Context, param interface{}) bool {
+	key := genCacheKey(c, this.cache, param)
+	if c.GetHeader("Cache-Control") != "no-cache" {
+		body, ok, err := this.cache.Store.Get(key)
+		if err != nil {
+			logger.FromContext(c).WithError(err).WithField("path", this.path).Warn("failed to get api cache")
+		}
+		if ok {
+			this.writeCache(c, body, "HIT")
+			return false
+		}
+	}
+
+	data, err := this.handler(c, param)
+	if c.IsAborted() {
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &Output{Code: 0, Message: err.Error(), Data: data})
+		return false
+	}
+	body, err := json.Marshal(&Output{Code: 1, Message: SUCCESS, Data: data})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, &Output{Code: 0, Message: err.Error(), Data: ""})
+		return true
+	}
+	if err = this.cache.Store.Set(key, body, this.cache.TTL, this.cache.Tags...); err != nil {
+		logger.FromContext(c).WithError(err).WithField("path", this.path).Warn("failed to set api cache")
+	}
+	this.writeCache(c, body, "MISS")
+	return true
+}
+
+func (this *httpHandler) writeCache(c *gin.Context, body []byte, status string) {
+	if cacheControl := this.cache.cacheControl(); len(cacheControl) > 0 {
+		c.Header("Cache-Control", cacheControl)
+	}
+	c.Header("X-Cache", status)
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// newLogger 生成本次请求的日志，并存入c.Request的context与c.Keys中
+func (this *httpHandler) newLogger(c *gin.Context) *logrus.Entry {
+	requestId := c.GetHeader("X-Request-Id")
+	if len(requestId) == 0 {
+		random := make([]byte, 16)
+		rand.Read(random)
+		requestId = hex.EncodeToString(random)
+	}
+	c.Header("X-Request-Id", requestId)
+	entry := logger.FromContext(c.Request.Context()).WithFields(logrus.Fields{"requestId": requestId, "uri": c.Request.RequestURI, "method": c.Request.Method, "ip": c.ClientIP()})
+	c.Request = c.Request.WithContext(logger.NewContext(c.Request.Context(), entry))
+	c.Set(logger.ContextKey, entry)
+	return entry
+}
+
 func (this *httpHandler) getData(param interface{}) map[string]interface{} {
 	// 敏感字段屏蔽，待优化
 	jsonDatas, _ := json.Marshal(param)
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sayuri567/tool/module/logger"
+)
+
+// LogLevelParam 调整日志级别的参数，Level为空时恢复为配置的级别
+type LogLevelParam struct {
+	Level string `form:"level" json:"level"`
+	// 非必需，只调整@type为该值的日志
+	Type string `form:"type" json:"type"`
+	// 非必需，只调整调用方包名以该值为前缀的日志
+	Package string `form:"package" json:"package"`
+	// 自动恢复的秒数，0为使用logger.Config.LevelTimeout，小于0为不恢复
+	Timeout int `form:"timeout" json:"timeout"`
+	// 恢复所有调整过的级别
+	Reset bool `form:"reset" json:"reset"`
+}
+
+// RegisterLogLevelHandler 注册查看(GET)与调整(POST)日志级别的接口，需自行通过GroupFilter做权限校验
+func (this *ApiModule) RegisterLogLevelHandler(path string) {
+	this.RegisterHandler(http.MethodGet, path, getLogLevel, nil)
+	this.RegisterHandler(http.MethodPost, path, setLogLevel, &LogLevelParam{})
+}
+
+func RegisterLogLevelHandler(path string) {
+	apiModule.RegisterLogLevelHandler(path)
+}
+
+func getLogLevel(c *gin.Context, p interface{}) (interface{}, error) {
+	return logger.GetLevelStatus()
+}
+
+func setLogLevel(c *gin.Context, p interface{}) (interface{}, error) {
+	param := p.(*LogLevelParam)
+	timeout := time.Duration(param.Timeout) * time.Second
+	var err error
+	switch {
+	case param.Reset:
+		err = logger.ResetLevel()
+	case len(param.Type) > 0:
+		err = logger.SetTypeLevel(param.Type, param.Level, timeout)
+	case len(param.Package) > 0:
+		err = logger.SetPackageLevel(param.Package, param.Level, timeout)
+	default:
+		err = logger.SetLevel(param.Level, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return logger.GetLevelStatus()
+}
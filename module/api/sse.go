@@ -0,0 +1,271 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSSEHeartbeat    = 15 * time.Second
+	defaultSSEReplaySize   = 100
+	defaultSSEClientBuffer = 64
+)
+
+// SSEConfig SSE配置
+type SSEConfig struct {
+	// 心跳间隔，默认15s
+	Heartbeat time.Duration
+
+	// 每个topic保留的历史消息数，用于Last-Event-ID断线续传，默认100
+	ReplaySize int
+
+	// 每个客户端的消息缓冲数，缓冲满时断开客户端，由客户端重连后续传，默认64
+	ClientBuffer int
+
+	// 非必需，客户端重连间隔，单位毫秒
+	Retry int
+}
+
+// SSEEvent 推送给客户端的消息
+type SSEEvent struct {
+	Id    uint64
+	Topic string
+	Event string
+	Data  interface{}
+}
+
+// SSEHub 按topic管理订阅者并广播消息
+type SSEHub struct {
+	config  *SSEConfig
+	lastId  uint64
+	clients map[string]map[*sseClient]struct{}
+	replays map[string][]*SSEEvent
+	closed  bool
+	done    chan struct{}
+	lock    sync.RWMutex
+}
+
+type sseClient struct {
+	events chan *SSEEvent
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (this *sseClient) close() {
+	this.once.Do(func() {
+		close(this.done)
+	})
+}
+
+// NewSSEHub NewSSEHub
+func NewSSEHub(config *SSEConfig) *SSEHub {
+	if config == nil {
+		config = &SSEConfig{}
+	}
+	if config.Heartbeat <= 0 {
+		config.Heartbeat = defaultSSEHeartbeat
+	}
+	if config.ReplaySize < 0 {
+		config.ReplaySize = 0
+	} else if config.ReplaySize == 0 {
+		config.ReplaySize = defaultSSEReplaySize
+	}
+	if config.ClientBuffer < 1 {
+		config.ClientBuffer = defaultSSEClientBuffer
+	}
+	return &SSEHub{
+		config:  config,
+		clients: make(map[string]map[*sseClient]struct{}),
+		replays: make(map[string][]*SSEEvent),
+		done:    make(chan struct{}),
+	}
+}
+
+// Publish 向订阅topic的所有客户端广播消息，返回消息id
+func (this *SSEHub) Publish(topic string, event string, data interface{}) uint64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.lastId++
+	msg := &SSEEvent{Id: this.lastId, Topic: topic, Event: event, Data: data}
+	if this.config.ReplaySize > 0 {
+		replay := append(this.replays[topic], msg)
+		if len(replay) > this.config.ReplaySize {
+			replay = replay[len(replay)-this.config.ReplaySize:]
+		}
+		this.replays[topic] = replay
+	}
+	for client := range this.clients[topic] {
+		select {
+		case client.events <- msg:
+		default:
+			logrus.WithField("topic", topic).Warn("sse client buffer is full, close it")
+			client.close()
+		}
+	}
+	return msg.Id
+}
+
+// Subscribers 当前订阅topic的客户端数量
+func (this *SSEHub) Subscribers(topic string) int {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return len(this.clients[topic])
+}
+
+// Close 断开所有客户端
+func (this *SSEHub) Close() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return
+	}
+	this.closed = true
+	close(this.done)
+}
+
+func (this *SSEHub) subscribe(topics []string, lastId uint64) (*sseClient, []*SSEEvent) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	client := &sseClient{events: make(chan *SSEEvent, this.config.ClientBuffer), done: make(chan struct{})}
+	replay := []*SSEEvent{}
+	for _, topic := range topics {
+		if _, ok := this.clients[topic]; !ok {
+			this.clients[topic] = make(map[*sseClient]struct{})
+		}
+		this.clients[topic][client] = struct{}{}
+		if lastId == 0 {
+			continue
+		}
+		for _, msg := range this.replays[topic] {
+			if msg.Id > lastId {
+				replay = append(replay, msg)
+			}
+		}
+	}
+	sort.Slice(replay, func(i, j int) bool { return replay[i].Id < replay[j].Id })
+	return client, replay
+}
+
+func (this *SSEHub) unsubscribe(client *sseClient, topics []string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, topic := range topics {
+		delete(this.clients[topic], client)
+		if len(this.clients[topic]) == 0 {
+			delete(this.clients, topic)
+		}
+	}
+	client.close()
+}
+
+// serve 输出事件流直到客户端断开或hub关闭
+func (this *SSEHub) serve(g *gin.Context, topics []string) {
+	lastId, _ := strconv.ParseUint(g.GetHeader("Last-Event-ID"), 10, 64)
+	if lastId == 0 {
+		lastId, _ = strconv.ParseUint(g.Query("lastEventId"), 10, 64)
+	}
+
+	header := g.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	g.Status(http.StatusOK)
+	if this.config.Retry > 0 {
+		fmt.Fprintf(g.Writer, "retry: %d\n\n", this.config.Retry)
+	}
+
+	client, replay := this.subscribe(topics, lastId)
+	defer this.unsubscribe(client, topics)
+	for _, msg := range replay {
+		if err := writeSSEEvent(g.Writer, msg); err != nil {
+			return
+		}
+	}
+	g.Writer.Flush()
+
+	ticker := time.NewTicker(this.config.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-client.events:
+			if msg.Id <= lastId {
+				continue
+			}
+			if err := writeSSEEvent(g.Writer, msg); err != nil {
+				return
+			}
+			g.Writer.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(g.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			g.Writer.Flush()
+		case <-client.done:
+			return
+		case <-this.done:
+			return
+		case <-g.Request.Context().Done():
+			return
+		}
+	}
+}
+
+func writeSSEEvent(w gin.ResponseWriter, msg *SSEEvent) error {
+	var data string
+	switch v := msg.Data.(type) {
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		bytes, err := json.Marshal(v)
+		if err != nil {
+			logrus.WithError(err).WithField("topic", msg.Topic).Error("failed to encode sse message")
+			return nil
+		}
+		data = string(bytes)
+	}
+
+	buf := strings.Builder{}
+	buf.WriteString("id: " + strconv.FormatUint(msg.Id, 10) + "\n")
+	if len(msg.Event) > 0 {
+		buf.WriteString("event: " + msg.Event + "\n")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	_, err := w.WriteString(buf.String())
+	return err
+}
+
+// RegisterSSEHandler 注册SSE接口，topics返回当前请求订阅的topic，返回错误时拒绝订阅
+func (this *ApiModule) RegisterSSEHandler(path string, hub *SSEHub, topics func(*gin.Context) ([]string, error)) {
+	this.sseHubs = append(this.sseHubs, hub)
+	this.RegisterHandler(http.MethodGet, path, func(g *gin.Context, p interface{}) (interface{}, error) {
+		subTopics, err := topics(g)
+		if err != nil {
+			return nil, err
+		}
+		if len(subTopics) == 0 {
+			return nil, fmt.Errorf("no topic to subscribe")
+		}
+		hub.serve(g, subTopics)
+		g.Abort()
+		return nil, nil
+	}, nil)
+}
+
+func RegisterSSEHandler(path string, hub *SSEHub, topics func(*gin.Context) ([]string, error)) {
+	apiModule.RegisterSSEHandler(path, hub, topics)
+}
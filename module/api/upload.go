@@ -0,0 +1,362 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sayuri567/tool/util/fileutil"
+)
+
+const (
+	defaultUploadDir       = "upload"
+	defaultFormValueLimit  = 10 << 20
+	defaultUploadSniffSize = 512
+)
+
+var (
+	ErrFileTooLarge     = errors.New("upload file too large")
+	ErrFileTypeNotAllow = errors.New("upload file type not allowed")
+	ErrFormTooLarge     = errors.New("form values too large")
+)
+
+// UploadFile 上传文件的信息，在prototype中声明为*UploadFile或[]*UploadFile，并通过upload tag配置限制.
+// mime按文件内容检测（http.DetectContentType），只能识别常见格式，检测为application/zip（如docx、xlsx）
+// 或text/plain（如json、csv）时使用part声明的Content-Type，声明的类型需与检测结果兼容.
+// handler返回错误或中止请求时删除已上传的文件，需保留文件时handler需返回nil.
+// e.g.
+//
+//	type AvatarParam struct {
+//		UserId int               `form:"userId"`
+//		Avatar *api.UploadFile   `form:"avatar" upload:"maxSize=2M,mime=image/png|image/jpeg"`
+//		Files  []*api.UploadFile `form:"files" upload:"maxSize=100M,mime=*"`
+//	}
+type UploadFile struct {
+	Field    string `form:"-" json:"field"`
+	FileName string `form:"-" json:"fileName"`
+	MimeType string `form:"-" json:"mimeType"`
+	Size     int64  `form:"-" json:"size"`
+	// 存储后端返回的路径
+	Path string `form:"-" json:"path"`
+}
+
+// UploadStorage 上传文件的存储后端
+type UploadStorage interface {
+	// Save 保存文件，返回存储路径
+	Save(ctx context.Context, name string, contentType string, reader io.Reader) (string, error)
+	Delete(ctx context.Context, path string) error
+}
+
+// LocalStorage 保存到本地磁盘
+type LocalStorage struct {
+	root string
+}
+
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{root: root}
+}
+
+func (this *LocalStorage) Save(ctx context.Context, name string, contentType string, reader io.Reader) (string, error) {
+	filePath := fileutil.Combine(this.root, name)
+	if _, err := fileutil.CreateFileFromReader(filePath, reader); err != nil {
+		return "", err
+	}
+	return filePath, nil
+}
+
+func (this *LocalStorage) Delete(ctx context.Context, path string) error {
+	return fileutil.DeleteFile(path)
+}
+
+// S3Client S3兼容的对象存储客户端，由业务方适配aws sdk、minio等实现，size未知时为-1
+type S3Client interface {
+	PutObject(ctx context.Context, bucket, key string, reader io.Reader, size int64, contentType string) error
+	RemoveObject(ctx context.Context, bucket, key string) error
+}
+
+// S3Storage 保存到S3兼容的对象存储，返回的路径为对象的key
+type S3Storage struct {
+	client S3Client
+	bucket string
+	prefix string
+}
+
+func NewS3Storage(client S3Client, bucket string, prefix string) *S3Storage {
+	return &S3Storage{client: client, bucket: bucket, prefix: strings.Trim(prefix, "/")}
+}
+
+func (this *S3Storage) Save(ctx context.Context, name string, contentType string, reader io.Reader) (string, error) {
+	key := name
+	if len(this.prefix) > 0 {
+		key = this.prefix + "/" + name
+	}
+	if err := this.client.PutObject(ctx, this.bucket, key, reader, -1, contentType); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (this *S3Storage) Delete(ctx context.Context, path string) error {
+	return this.client.RemoveObject(ctx, this.bucket, path)
+}
+
+type uploadField struct {
+	index    int
+	name     string
+	maxSize  int64
+	mimes    []string
+	multiple bool
+}
+
+var (
+	uploadFileType      = reflect.TypeOf(&UploadFile{})
+	uploadFileSliceType = reflect.TypeOf([]*UploadFile{})
+)
+
+// parseUploadFields 解析prototype中的上传字段
+func parseUploadFields(prototype interface{}) (map[string]*uploadField, error) {
+	if prototype == nil {
+		return nil, nil
+	}
+	objT := reflect.TypeOf(prototype)
+	if objT.Kind() != reflect.Ptr || objT.Elem().Kind() != reflect.Struct {
+		return nil, nil
+	}
+	objT = objT.Elem()
+	var fields map[string]*uploadField
+	for i := 0; i < objT.NumField(); i++ {
+		fieldT := objT.Field(i)
+		if fieldT.Type != uploadFileType && fieldT.Type != uploadFileSliceType {
+			continue
+		}
+		field := &uploadField{index: i, name: fieldT.Name, multiple: fieldT.Type == uploadFileSliceType}
+		if tag := strings.Split(fieldT.Tag.Get("form"), ",")[0]; len(tag) > 0 {
+			field.name = tag
+		}
+		for _, opt := range strings.Split(fieldT.Tag.Get("upload"), ",") {
+			kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "maxSize":
+				size, err := parseSize(kv[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid upload maxSize of %v: %v", fieldT.Name, err)
+				}
+				field.maxSize = size
+			case "mime":
+				if kv[1] != "*" {
+					field.mimes = strings.Split(kv[1], "|")
+				}
+			}
+		}
+		if fields == nil {
+			fields = make(map[string]*uploadField)
+		}
+		fields[field.name] = field
+	}
+	return fields, nil
+}
+
+// parseSize 解析文件大小，支持K、M、G后缀
+func parseSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSuffix(strings.ToUpper(size), "B"))
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(size, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(size, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(size, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		size = size[:len(size)-1]
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * unit, nil
+}
+
+func (this *uploadField) allowMime(mimeType string) bool {
+	if len(this.mimes) == 0 {
+		return true
+	}
+	mimeType = strings.TrimSpace(strings.Split(mimeType, ";")[0])
+	for _, allow := range this.mimes {
+		if allow == mimeType || (strings.HasSuffix(allow, "/*") && strings.HasPrefix(mimeType, allow[:len(allow)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// declaredMimeMatches 声明的类型是否属于内容检测无法细分的类型，detected为application/zip时可声明为基于zip的文档格式，
+// 为text/plain时可声明为文本格式，其他检测结果不使用声明的类型
+func declaredMimeMatches(detected string, declared string) bool {
+	detected = strings.TrimSpace(strings.Split(detected, ";")[0])
+	declared = strings.ToLower(strings.TrimSpace(strings.Split(declared, ";")[0]))
+	switch detected {
+	case "application/zip":
+		return strings.HasPrefix(declared, "application/vnd.openxmlformats-officedocument.") ||
+			strings.HasPrefix(declared, "application/vnd.oasis.opendocument.") ||
+			declared == "application/epub+zip" || declared == "application/java-archive"
+	case "text/plain":
+		return strings.HasPrefix(declared, "text/") || declared == "application/json" ||
+			strings.HasSuffix(declared, "+json") || declared == "application/xml"
+	}
+	return false
+}
+
+// sizeLimitReader 超过max时返回err
+type sizeLimitReader struct {
+	reader io.Reader
+	size   int64
+	max    int64
+	err    error
+}
+
+func (this *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := this.reader.Read(p)
+	this.size += int64(n)
+	if this.max > 0 && this.size > this.max {
+		return n, this.err
+	}
+	return n, err
+}
+
+// parseUpload 流式读取multipart请求，将文件写入存储后端，普通字段交给c.Bind处理
+func (this *httpHandler) parseUpload(c *gin.Context) (map[string][]*UploadFile, error) {
+	if this.module.config.MaxUploadSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, this.module.config.MaxUploadSize)
+	}
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]*UploadFile{}
+	values := map[string][]string{}
+	valueSize := int64(0)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			this.deleteUploads(c, files)
+			return nil, err
+		}
+		name := part.FormName()
+		if len(part.FileName()) == 0 {
+			limit := &sizeLimitReader{reader: part, size: valueSize, max: defaultFormValueLimit, err: ErrFormTooLarge}
+			value, err := ioutil.ReadAll(limit)
+			part.Close()
+			if err != nil {
+				this.deleteUploads(c, files)
+				return nil, err
+			}
+			valueSize = limit.size
+			values[name] = append(values[name], string(value))
+			continue
+		}
+
+		field, ok := this.uploadFields[name]
+		if !ok || (!field.multiple && len(files[name]) > 0) {
+			part.Close()
+			continue
+		}
+		file, err := this.saveUpload(c, field, part)
+		part.Close()
+		if err != nil {
+			this.deleteUploads(c, files)
+			return nil, err
+		}
+		files[name] = append(files[name], file)
+	}
+
+	c.Request.MultipartForm = &multipart.Form{Value: values}
+	c.Request.Form = values
+	c.Request.PostForm = values
+	return files, nil
+}
+
+func (this *httpHandler) saveUpload(c *gin.Context, field *uploadField, part *multipart.Part) (*UploadFile, error) {
+	head := make([]byte, defaultUploadSniffSize)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	mimeType := http.DetectContentType(head)
+	if !field.allowMime(mimeType) {
+		declared := part.Header.Get("Content-Type")
+		if !declaredMimeMatches(mimeType, declared) || !field.allowMime(declared) {
+			return nil, fmt.Errorf("%w: %v %v", ErrFileTypeNotAllow, field.name, mimeType)
+		}
+		mimeType = declared
+	}
+
+	reader := &sizeLimitReader{reader: io.MultiReader(bytes.NewReader(head), part), max: field.maxSize, err: ErrFileTooLarge}
+	storePath, err := this.module.config.UploadStorage.Save(c.Request.Context(), genUploadName(part.FileName()), mimeType, reader)
+	if err != nil {
+		if errors.Is(err, ErrFileTooLarge) {
+			return nil, fmt.Errorf("%w: %v", ErrFileTooLarge, field.name)
+		}
+		return nil, err
+	}
+
+	return &UploadFile{
+		Field:    field.name,
+		FileName: part.FileName(),
+		MimeType: mimeType,
+		Size:     reader.size,
+		Path:     storePath,
+	}, nil
+}
+
+// setUploads 将上传结果写入参数
+func (this *httpHandler) setUploads(param interface{}, files map[string][]*UploadFile) {
+	paramV := reflect.ValueOf(param).Elem()
+	for name, field := range this.uploadFields {
+		if len(files[name]) == 0 {
+			continue
+		}
+		if field.multiple {
+			paramV.Field(field.index).Set(reflect.ValueOf(files[name]))
+		} else {
+			paramV.Field(field.index).Set(reflect.ValueOf(files[name][0]))
+		}
+	}
+}
+
+func (this *httpHandler) deleteUploads(c *gin.Context, files map[string][]*UploadFile) {
+	for _, list := range files {
+		for _, file := range list {
+			this.module.config.UploadStorage.Delete(c.Request.Context(), file.Path)
+		}
+	}
+}
+
+// genUploadName 按日期分目录，并生成随机文件名
+func genUploadName(fileName string) string {
+	random := make([]byte, 8)
+	rand.Read(random)
+	return time.Now().Format("2006/01/02/") + strconv.FormatInt(time.Now().UnixNano(), 36) + hex.EncodeToString(random) + strings.ToLower(path.Ext(fileName))
+}
@@ -1,10 +1,12 @@
 package crontab
 
 import (
+	"context"
 	"reflect"
 
 	"github.com/robfig/cron/v3"
 	"github.com/sayuri567/tool/module"
+	applog "github.com/sayuri567/tool/module/logger"
 	"github.com/sirupsen/logrus"
 )
 
@@ -13,6 +15,11 @@ type Crontab struct {
 	Cmd  cron.Job
 }
 
+// ContextJob Cmd可选实现的接口，实现后以RunContext代替Run执行，通过logger.FromContext获取携带cronName的日志
+type ContextJob interface {
+	RunContext(ctx context.Context)
+}
+
 type CrontabModule struct {
 	*module.DefaultModule
 
@@ -60,15 +67,20 @@ func (m *CrontabModule) Stop() {
 func (m *CrontabModule) skipIfStillRunning() cron.JobWrapper {
 	return func(j cron.Job) cron.Job {
 		var name = reflect.TypeOf(j).String()
+		var entry = logrus.WithField("cronName", name)
 		limitCh := make(chan struct{}, 1)
 		limitCh <- struct{}{}
 		return cron.FuncJob(func() {
 			select {
 			case v := <-limitCh:
 				defer func() { limitCh <- v }()
-				j.Run()
+				if contextJob, ok := j.(ContextJob); ok {
+					contextJob.RunContext(applog.NewContext(context.Background(), entry))
+				} else {
+					j.Run()
+				}
 			default:
-				logrus.WithField("cronName", name).Info("skip crontab")
+				entry.Info("skip crontab")
 			}
 		})
 	}
@@ -11,9 +11,11 @@ import (
 
 	"github.com/sayuri567/gorun"
 	"github.com/sayuri567/tool/module"
+	"github.com/sayuri567/tool/module/logger"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 )
 
 // ServerConfig ServerConfig
@@ -130,12 +132,19 @@ func (this *ServerModule) genCreds() (grpc.ServerOption, error) {
 
 func (this *ServerModule) interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	defer gorun.Recover("grpc panic")
+	entry := logger.FromContext(ctx).WithField("method", info.FullMethod)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if requestId := md.Get("x-request-id"); len(requestId) > 0 {
+			entry = entry.WithField("requestId", requestId[0])
+		}
+	}
+	ctx = logger.NewContext(ctx, entry)
 	if this.config.AccessLog {
-		logrus.WithFields(logrus.Fields{"method": info.FullMethod}).Info("grpc access log")
+		entry.Info("grpc access log")
 	}
 	resp, err := handler(ctx, req)
 	if err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{"method": info.FullMethod, "req": req}).Warn("grpc error")
+		entry.WithError(err).WithField("req", req).Warn("grpc error")
 	}
 	return resp, err
 }
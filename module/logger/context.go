@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ContextKey gin.Context.Value只支持字符串key，api模块会同时以该key将日志写入c.Keys
+const ContextKey = "@logger"
+
+type entryKey struct{}
+
+// FromContext 获取ctx中的日志，不存在时返回标准logger的entry
+func FromContext(ctx context.Context) *logrus.Entry {
+	if ctx == nil {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+	if entry, ok := ctx.Value(entryKey{}).(*logrus.Entry); ok && entry != nil {
+		return entry
+	}
+	if entry, ok := ctx.Value(ContextKey).(*logrus.Entry); ok && entry != nil {
+		return entry
+	}
+	return logrus.NewEntry(logrus.StandardLogger()).WithContext(ctx)
+}
+
+// NewContext 将日志存入ctx
+func NewContext(ctx context.Context, entry *logrus.Entry) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// AddField 在ctx中的日志上追加字段，返回新的ctx
+func AddField(ctx context.Context, key string, value interface{}) context.Context {
+	return NewContext(ctx, FromContext(ctx).WithField(key, value))
+}
+
+// AddFields 在ctx中的日志上追加字段，返回新的ctx
+func AddFields(ctx context.Context, fields logrus.Fields) context.Context {
+	return NewContext(ctx, FromContext(ctx).WithFields(fields))
+}
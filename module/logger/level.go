@@ -0,0 +1,280 @@
+package logger
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sayuri567/tool/module/signal"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// levelDropKey 低于当前生效级别的日志会带上该字段，由未单独配置级别的输出跳过
+	levelDropKey = "@levelDropped"
+
+	levelKindGlobal  = "global"
+	levelKindType    = "type"
+	levelKindPackage = "package"
+
+	defaultLevelTimeout = 30 * time.Minute
+)
+
+var errLoggerNotInited = errors.New("logger module not inited")
+
+// LevelStatus 当前生效的日志级别
+type LevelStatus struct {
+	Level    string            `json:"level"`
+	Base     string            `json:"base"`
+	Types    map[string]string `json:"types"`
+	Packages map[string]string `json:"packages"`
+}
+
+// levelController 运行时的日志级别，按@type、调用方包名、全局的顺序决定日志的生效级别
+type levelController struct {
+	base      logrus.Level
+	global    logrus.Level
+	sinkLevel logrus.Level
+	types     map[string]logrus.Level
+	packages  map[string]logrus.Level
+	timers    map[string]*time.Timer
+	timeout   time.Duration
+	lock      sync.RWMutex
+}
+
+func newLevelController(base logrus.Level, sinkLevel logrus.Level, timeout time.Duration) *levelController {
+	if timeout == 0 {
+		timeout = defaultLevelTimeout
+	}
+	this := &levelController{
+		base:      base,
+		global:    base,
+		sinkLevel: sinkLevel,
+		types:     make(map[string]logrus.Level),
+		packages:  make(map[string]logrus.Level),
+		timers:    make(map[string]*time.Timer),
+		timeout:   timeout,
+	}
+	this.apply()
+	return this
+}
+
+// drop 返回true时日志低于生效级别
+func (this *levelController) drop(e *logrus.Entry) bool {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return e.Level > this.effective(e)
+}
+
+func (this *levelController) effective(e *logrus.Entry) logrus.Level {
+	if len(this.types) > 0 {
+		if tp, ok := e.Data["@type"].(string); ok {
+			if level, ok := this.types[tp]; ok {
+				return level
+			}
+		}
+	}
+	if len(this.packages) > 0 {
+		if pkg := callerPackage(e); len(pkg) > 0 {
+			matched := ""
+			for prefix := range this.packages {
+				if (pkg == prefix || strings.HasPrefix(pkg, prefix+"/")) && len(prefix) > len(matched) {
+					matched = prefix
+				}
+			}
+			if len(matched) > 0 {
+				return this.packages[matched]
+			}
+		}
+	}
+	return this.global
+}
+
+// set 设置级别，timeout小于0时不自动恢复，为0时使用默认时间
+func (this *levelController) set(kind string, name string, level logrus.Level, timeout time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	switch kind {
+	case levelKindType:
+		this.types[name] = level
+	case levelKindPackage:
+		this.packages[name] = level
+	default:
+		this.global = level
+	}
+
+	key := kind + ":" + name
+	if timer, ok := this.timers[key]; ok {
+		timer.Stop()
+		delete(this.timers, key)
+	}
+	if timeout == 0 {
+		timeout = this.timeout
+	}
+	if timeout > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(timeout, func() {
+			this.lock.Lock()
+			if this.timers[key] != timer {
+				this.lock.Unlock()
+				return
+			}
+			this.reset(kind, name)
+			this.lock.Unlock()
+			// 输出日志时会获取读锁，需在释放锁之后
+			logrus.WithFields(logrus.Fields{"kind": kind, "name": name}).Info("log level reverted")
+		})
+		this.timers[key] = timer
+	}
+	this.apply()
+}
+
+// reset 恢复为配置的级别，调用方需持有锁
+func (this *levelController) reset(kind string, name string) {
+	switch kind {
+	case levelKindType:
+		delete(this.types, name)
+	case levelKindPackage:
+		delete(this.packages, name)
+	default:
+		this.global = this.base
+	}
+	key := kind + ":" + name
+	if timer, ok := this.timers[key]; ok {
+		timer.Stop()
+		delete(this.timers, key)
+	}
+	this.apply()
+}
+
+func (this *levelController) resetAll() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, timer := range this.timers {
+		timer.Stop()
+	}
+	this.timers = make(map[string]*time.Timer)
+	this.types = make(map[string]logrus.Level)
+	this.packages = make(map[string]logrus.Level)
+	this.global = this.base
+	this.apply()
+}
+
+// apply logrus的级别取所有配置中最详细的级别，再由drop过滤
+func (this *levelController) apply() {
+	level := this.global
+	if this.sinkLevel > level {
+		level = this.sinkLevel
+	}
+	for _, l := range this.types {
+		if l > level {
+			level = l
+		}
+	}
+	for _, l := range this.packages {
+		if l > level {
+			level = l
+		}
+	}
+	logrus.SetLevel(level)
+}
+
+func (this *levelController) status() *LevelStatus {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	status := &LevelStatus{
+		Level:    this.global.String(),
+		Base:     this.base.String(),
+		Types:    make(map[string]string, len(this.types)),
+		Packages: make(map[string]string, len(this.packages)),
+	}
+	for tp, level := range this.types {
+		status.Types[tp] = level.String()
+	}
+	for pkg, level := range this.packages {
+		status.Packages[pkg] = level.String()
+	}
+	return status
+}
+
+// callerPackage 从调用方函数名中解析包名，如github.com/a/b.(*T).Func解析为github.com/a/b
+func callerPackage(e *logrus.Entry) string {
+	if e.Caller == nil {
+		return ""
+	}
+	fn := e.Caller.Function
+	lastSlash := strings.LastIndex(fn, "/")
+	if dot := strings.Index(fn[lastSlash+1:], "."); dot >= 0 {
+		return fn[:lastSlash+1+dot]
+	}
+	return fn
+}
+
+func (this *LoggerModule) setLevel(kind string, name string, levelStr string, timeout time.Duration) error {
+	if this.levels == nil {
+		return errLoggerNotInited
+	}
+	if len(levelStr) == 0 {
+		this.levels.lock.Lock()
+		this.levels.reset(kind, name)
+		this.levels.lock.Unlock()
+		return nil
+	}
+	level, err := logrus.ParseLevel(levelStr)
+	if err != nil {
+		return err
+	}
+	this.levels.set(kind, name, level, timeout)
+	logrus.WithFields(logrus.Fields{"kind": kind, "name": name, "level": levelStr, "timeout": timeout.String()}).Info("log level changed")
+	return nil
+}
+
+// SetLevel 调整全局日志级别，timeout后恢复为配置的级别，timeout为0时使用Config.LevelTimeout，小于0时不恢复，level为空时立即恢复
+func SetLevel(level string, timeout time.Duration) error {
+	return loggerModule.setLevel(levelKindGlobal, "", level, timeout)
+}
+
+// SetTypeLevel 调整@type为tp的日志级别，如access、mysql
+func SetTypeLevel(tp string, level string, timeout time.Duration) error {
+	return loggerModule.setLevel(levelKindType, tp, level, timeout)
+}
+
+// SetPackageLevel 调整调用方包名以pkg为前缀的日志级别，如github.com/sayuri567/tool/module/queue
+func SetPackageLevel(pkg string, level string, timeout time.Duration) error {
+	return loggerModule.setLevel(levelKindPackage, pkg, level, timeout)
+}
+
+// ResetLevel 将所有调整过的级别恢复为配置的级别
+func ResetLevel() error {
+	if loggerModule.levels == nil {
+		return errLoggerNotInited
+	}
+	loggerModule.levels.resetAll()
+	logrus.Info("log level reset")
+	return nil
+}
+
+// GetLevelStatus 获取当前生效的日志级别
+func GetLevelStatus() (*LevelStatus, error) {
+	if loggerModule.levels == nil {
+		return nil, errLoggerNotInited
+	}
+	return loggerModule.levels.status(), nil
+}
+
+// SetLevelSignal 收到信号时将全局级别调整为level，timeout后自动恢复，已调整时再次收到信号则立即恢复
+// 需在signal模块Init之前调用
+func SetLevelSignal(level string, timeout time.Duration, sigs ...os.Signal) {
+	signal.SetHandle(func() error {
+		status, err := GetLevelStatus()
+		if err != nil {
+			return err
+		}
+		if status.Level != status.Base {
+			return SetLevel("", 0)
+		}
+		return SetLevel(level, timeout)
+	}, sigs...)
+}
@@ -1,10 +1,9 @@
 package logger
 
 import (
+	"io/ioutil"
 	"time"
 
-	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
-	"github.com/rifflock/lfshook"
 	"github.com/sayuri567/tool/module"
 	"github.com/sirupsen/logrus"
 )
@@ -16,6 +15,23 @@ type Config struct {
 	RotationTime time.Duration
 	TimeFormat   string
 	ExtendFields map[string]string
+
+	// 日志输出，为空时保持默认输出，并在LogFile不为空时输出到文件
+	// 不为空时只输出到配置的Sinks中
+	Sinks []*SinkConfig
+
+	// 日志采样规则，按顺序匹配第一条规则
+	Sampling []*SamplingRule
+	// 采样丢弃汇总日志的输出间隔，默认1分钟
+	SamplingSummaryInterval time.Duration
+
+	// 运行时调整日志级别后自动恢复的时间，默认30分钟
+	LevelTimeout time.Duration
+
+	// 需要脱敏的字段名，不区分大小写，日志数据中该字段的值（包括嵌套的字段）替换为***
+	RedactFields []string
+	// 需要脱敏的正则，日志消息与数据中匹配到的内容替换为***，可使用RedactPhonePattern等
+	RedactPatterns []string
 }
 
 // LoggerModule LoggerModule
@@ -23,8 +39,17 @@ type LoggerModule struct {
 	*module.DefaultModule
 	config    *Config
 	formatter logrus.Formatter
+	sinks     []*sinkHook
+	sampler   *sampler
+	levels    *levelController
+	redactor  *redactor
 }
 
+const (
+	defaultRotationTime = time.Hour * 24
+	defaultMaxRemainCnt = 10
+)
+
 var loggerModule = &LoggerModule{}
 
 func GetLoggerModule() *LoggerModule {
@@ -37,10 +62,10 @@ func SetConfig(config *Config) {
 	}
 	if len(config.LogFile) > 0 {
 		if config.RotationTime < time.Minute {
-			config.RotationTime = time.Hour * 24
+			config.RotationTime = defaultRotationTime
 		}
 		if config.MaxRemainCnt < 1 {
-			config.MaxRemainCnt = 10
+			config.MaxRemainCnt = defaultMaxRemainCnt
 		}
 	}
 	if len(config.Level) == 0 {
@@ -61,6 +86,15 @@ func (this *LoggerModule) Fire(e *logrus.Entry) error {
 			e.Data[key] = value
 		}
 	}
+	if this.redactor != nil {
+		this.redactor.redact(e)
+	}
+	if this.sampler != nil && this.sampler.drop(e) {
+		e.Data[samplingDropKey] = true
+	}
+	if this.levels != nil && this.levels.drop(e) {
+		e.Data[levelDropKey] = true
+	}
 	return nil
 }
 
@@ -77,7 +111,21 @@ func (this *LoggerModule) Init() error {
 		},
 	}
 	logrus.ErrorKey = "@error"
-	logrus.SetFormatter(this.formatter)
+	logrus.SetFormatter(&dropFormatter{Formatter: this.formatter})
+	if len(this.config.RedactFields) > 0 || len(this.config.RedactPatterns) > 0 {
+		redactor, err := newRedactor(this.config.RedactFields, this.config.RedactPatterns)
+		if err != nil {
+			return err
+		}
+		this.redactor = redactor
+	}
+	if len(this.config.Sampling) > 0 {
+		sampler, err := newSampler(this.config.Sampling, this.config.SamplingSummaryInterval)
+		if err != nil {
+			return err
+		}
+		this.sampler = sampler
+	}
 	logLevel := this.config.Level
 	if logLevel == "" {
 		logLevel = logrus.DebugLevel.String()
@@ -86,44 +134,55 @@ func (this *LoggerModule) Init() error {
 	if err != nil {
 		return err
 	}
-	logrus.SetReportCaller(true)
-	logrus.SetLevel(level)
-	logrus.AddHook(this)
-	if len(this.config.LogFile) > 0 {
-		hook, err := this.newLfsHook()
+	sinks := this.config.Sinks
+	if len(sinks) == 0 && len(this.config.LogFile) > 0 {
+		sinks = []*SinkConfig{{Type: SinkFile}}
+	}
+	sinkLevel := logrus.PanicLevel
+	for _, sinkConfig := range sinks {
+		hook, err := this.newSinkHook(sinkConfig)
 		if err != nil {
 			return err
 		}
+		// 单独配置了级别的输出可能比全局级别更详细，由各输出自行过滤
+		if hook.explicit && hook.maxLevel() > sinkLevel {
+			sinkLevel = hook.maxLevel()
+		}
+		this.sinks = append(this.sinks, hook)
+	}
+	if len(this.config.Sinks) > 0 {
+		logrus.SetOutput(ioutil.Discard)
+	}
+	logrus.SetReportCaller(true)
+	this.levels = newLevelController(level, sinkLevel, this.config.LevelTimeout)
+	logrus.AddHook(this)
+	for _, hook := range this.sinks {
 		logrus.AddHook(hook)
 	}
 	logrus.Info("logger module inited")
 	return nil
 }
 
-func (this *LoggerModule) newLfsHook() (logrus.Hook, error) {
-	writer, err := rotatelogs.New(
-		this.config.LogFile+".%Y%m%d%H",
-		rotatelogs.WithLinkName(this.config.LogFile),
-
-		rotatelogs.WithRotationTime(this.config.RotationTime),
-
-		//rotatelogs.WithMaxAge(time.Hour*24),
-		rotatelogs.WithRotationCount(uint(this.config.MaxRemainCnt)),
-	)
-
-	if err != nil {
-		logrus.Errorf("config local file system for logger error: %v", err)
-		return nil, err
+func (this *LoggerModule) Stop() {
+	if this.sampler != nil {
+		this.sampler.stop()
+	}
+	for _, hook := range this.sinks {
+		hook.writer.Flush()
 	}
+}
 
-	lfsHook := lfshook.NewHook(lfshook.WriterMap{
-		logrus.DebugLevel: writer,
-		logrus.InfoLevel:  writer,
-		logrus.WarnLevel:  writer,
-		logrus.ErrorLevel: writer,
-		logrus.FatalLevel: writer,
-		logrus.PanicLevel: writer,
-	}, this.formatter)
+// dropFormatter 默认输出跳过被采样丢弃或低于生效级别的日志
+type dropFormatter struct {
+	logrus.Formatter
+}
 
-	return lfsHook, nil
+func (this *dropFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	if _, ok := e.Data[samplingDropKey]; ok {
+		return nil, nil
+	}
+	if _, ok := e.Data[levelDropKey]; ok {
+		return nil, nil
+	}
+	return this.Formatter.Format(e)
 }
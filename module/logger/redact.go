@@ -0,0 +1,102 @@
+package logger
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const redactMask = "***"
+
+// 常用的脱敏正则，可直接用于Config.RedactPatterns
+const (
+	// RedactPhonePattern 手机号
+	RedactPhonePattern = `\b1[3-9]\d{9}\b`
+	// RedactIdCardPattern 身份证号
+	RedactIdCardPattern = `\b\d{17}[\dXx]\b`
+	// RedactBearerPattern Authorization中的Bearer token
+	RedactBearerPattern = `(?i)bearer\s+[a-z0-9\-._~+/]+=*`
+	// RedactJwtPattern jwt
+	RedactJwtPattern = `\beyJ[\w-]+\.[\w-]+\.[\w-]+`
+)
+
+// redactor 按字段名与正则对日志中的数据与消息脱敏，不修改调用方传入的对象
+type redactor struct {
+	fields   map[string]bool
+	patterns []*regexp.Regexp
+}
+
+func newRedactor(fields []string, patterns []string) (*redactor, error) {
+	this := &redactor{fields: make(map[string]bool, len(fields))}
+	for _, field := range fields {
+		this.fields[strings.ToLower(field)] = true
+	}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		this.patterns = append(this.patterns, re)
+	}
+	return this, nil
+}
+
+func (this *redactor) redact(e *logrus.Entry) {
+	e.Message = this.redactString(e.Message)
+	for key, value := range e.Data {
+		e.Data[key] = this.redactValue(key, value)
+	}
+}
+
+func (this *redactor) redactString(value string) string {
+	for _, re := range this.patterns {
+		value = re.ReplaceAllString(value, redactMask)
+	}
+	return value
+}
+
+func (this *redactor) redactValue(key string, value interface{}) interface{} {
+	if this.fields[strings.ToLower(key)] {
+		return redactMask
+	}
+	switch v := value.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	case string:
+		return this.redactString(v)
+	case error:
+		return this.redactString(v.Error())
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			redacted[k] = this.redactValue(k, vv)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, vv := range v {
+			redacted[i] = this.redactValue("", vv)
+		}
+		return redacted
+	}
+
+	switch reflect.Indirect(reflect.ValueOf(value)).Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		// 复杂类型（如grpc的req）转为json对象后脱敏
+		bytes, err := json.Marshal(value)
+		if err != nil {
+			return value
+		}
+		var generic interface{}
+		if err = json.Unmarshal(bytes, &generic); err != nil {
+			return value
+		}
+		return this.redactValue("", generic)
+	case reflect.String:
+		return this.redactString(reflect.Indirect(reflect.ValueOf(value)).String())
+	}
+	return value
+}
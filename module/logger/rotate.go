@@ -0,0 +1,194 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sayuri567/tool/util/fileutil"
+)
+
+// rotateWriter 按时间与文件大小切割的日志文件
+// 当前日志写入filename，切割后重命名为filename.YYYYmmddHH[.N]，可选gzip压缩，
+// 并按保留个数与总大小清理旧文件
+type rotateWriter struct {
+	filename     string
+	rotationTime time.Duration
+	maxSize      int64
+	maxRemainCnt int
+	maxTotalSize int64
+	compress     bool
+
+	file     *os.File
+	size     int64
+	rotateAt time.Time
+	lock     sync.Mutex
+	cleanMu  sync.Mutex
+}
+
+func newRotateWriter(filename string, rotationTime time.Duration, maxSize int64, maxRemainCnt int, maxTotalSize int64, compress bool) (*rotateWriter, error) {
+	writer := &rotateWriter{
+		filename:     filename,
+		rotationTime: rotationTime,
+		maxSize:      maxSize,
+		maxRemainCnt: maxRemainCnt,
+		maxTotalSize: maxTotalSize,
+		compress:     compress,
+	}
+	if err := writer.open(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (this *rotateWriter) open() error {
+	if dir := filepath.Dir(this.filename); dir != "." {
+		if err := fileutil.MakeDir(dir); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(this.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	this.file = file
+	this.size = info.Size()
+	if this.rotationTime > 0 {
+		this.rotateAt = time.Now().Truncate(this.rotationTime).Add(this.rotationTime)
+	}
+	return nil
+}
+
+func (this *rotateWriter) Write(p []byte) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.needRotate(int64(len(p))) {
+		if err := this.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := this.file.Write(p)
+	this.size += int64(n)
+	return n, err
+}
+
+func (this *rotateWriter) needRotate(size int64) bool {
+	if this.rotationTime > 0 && !time.Now().Before(this.rotateAt) {
+		return true
+	}
+	return this.maxSize > 0 && this.size > 0 && this.size+size > this.maxSize
+}
+
+func (this *rotateWriter) rotate() error {
+	if err := this.file.Close(); err != nil {
+		return err
+	}
+	backup := this.filename + "." + time.Now().Format("2006010215")
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backup); os.IsNotExist(err) {
+			if _, err := os.Stat(backup + ".gz"); os.IsNotExist(err) {
+				break
+			}
+		}
+		backup = fmt.Sprintf("%v.%v.%d", this.filename, time.Now().Format("2006010215"), i)
+	}
+	if err := os.Rename(this.filename, backup); err != nil {
+		return err
+	}
+	if err := this.open(); err != nil {
+		return err
+	}
+	go this.afterRotate(backup)
+	return nil
+}
+
+// afterRotate 压缩切割出的文件并清理旧文件
+func (this *rotateWriter) afterRotate(backup string) {
+	this.cleanMu.Lock()
+	defer this.cleanMu.Unlock()
+	if this.compress {
+		// 切割频繁时，文件可能已被之前的清理删除
+		if err := gzipFile(backup); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "failed to compress log file %v: %v\n", backup, err)
+		}
+	}
+	this.clean()
+}
+
+func (this *rotateWriter) clean() {
+	if this.maxRemainCnt < 1 && this.maxTotalSize < 1 {
+		return
+	}
+	files, err := fileutil.GetFiles(filepath.Dir(this.filename))
+	if err != nil {
+		return
+	}
+	prefix := filepath.Base(this.filename) + "."
+	backups := fileutil.Files{}
+	for _, file := range files {
+		if isBackupOf(file.Name, prefix) {
+			backups = append(backups, file)
+		}
+	}
+	sort.Sort(sort.Reverse(backups))
+
+	this.lock.Lock()
+	totalSize := this.size
+	this.lock.Unlock()
+	for i, file := range backups {
+		totalSize += file.Size
+		if (this.maxRemainCnt > 0 && i >= this.maxRemainCnt) || (this.maxTotalSize > 0 && totalSize > this.maxTotalSize) {
+			fileutil.DeleteFile(file.Path)
+		}
+	}
+}
+
+// isBackupOf 文件名为prefix加上10位时间，避免误删同目录下其他日志的文件，如app.log.error.*
+func isBackupOf(name string, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) || len(name) < len(prefix)+10 {
+		return false
+	}
+	for _, c := range name[len(prefix) : len(prefix)+10] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,169 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// samplingDropKey 被采样丢弃的日志会带上该字段，由各输出跳过
+	samplingDropKey = "@sampledOut"
+
+	samplingType                   = "sampling"
+	defaultSamplingInterval        = time.Second
+	defaultSamplingSummaryInterval = time.Minute
+)
+
+// SamplingRule 日志采样规则
+// 每个周期内同一分组的前First条全部输出，之后每Thereafter条输出1条，Thereafter为0时全部丢弃
+type SamplingRule struct {
+	// 匹配的日志消息，为空时匹配所有消息
+	Message string
+
+	// 匹配的级别，为空时匹配所有级别
+	Levels []string
+
+	// 分组使用的字段，如@type，为空时按消息分组
+	Key string
+
+	// 统计周期，默认1s
+	Interval time.Duration
+
+	First      int
+	Thereafter int
+}
+
+type samplingCounter struct {
+	start time.Time
+	count int
+}
+
+// sampler 按规则决定日志是否输出，并记录丢弃的条数
+type sampler struct {
+	rules    []*SamplingRule
+	levels   []map[logrus.Level]bool
+	counters map[string]*samplingCounter
+	dropped  map[string]uint64
+	lock     sync.Mutex
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newSampler(rules []*SamplingRule, summaryInterval time.Duration) (*sampler, error) {
+	this := &sampler{
+		rules:    rules,
+		levels:   make([]map[logrus.Level]bool, len(rules)),
+		counters: make(map[string]*samplingCounter),
+		dropped:  make(map[string]uint64),
+		done:     make(chan struct{}),
+	}
+	for i, rule := range rules {
+		if rule.Interval <= 0 {
+			rule.Interval = defaultSamplingInterval
+		}
+		if len(rule.Levels) == 0 {
+			continue
+		}
+		this.levels[i] = make(map[logrus.Level]bool)
+		for _, levelStr := range rule.Levels {
+			level, err := logrus.ParseLevel(levelStr)
+			if err != nil {
+				return nil, err
+			}
+			this.levels[i][level] = true
+		}
+	}
+	if summaryInterval <= 0 {
+		summaryInterval = defaultSamplingSummaryInterval
+	}
+	this.wg.Add(1)
+	go this.summary(summaryInterval)
+	return this, nil
+}
+
+// drop 返回true时日志不输出
+func (this *sampler) drop(e *logrus.Entry) bool {
+	if tp, _ := e.Data["@type"].(string); tp == samplingType {
+		return false
+	}
+	for i, rule := range this.rules {
+		if len(rule.Message) > 0 && rule.Message != e.Message {
+			continue
+		}
+		if this.levels[i] != nil && !this.levels[i][e.Level] {
+			continue
+		}
+		group := e.Message
+		if len(rule.Key) > 0 {
+			group = fmt.Sprintf("%v=%v", rule.Key, e.Data[rule.Key])
+		}
+		group = fmt.Sprintf("%d:%v", i, group)
+		return this.count(group, rule, e.Time)
+	}
+	return false
+}
+
+func (this *sampler) count(group string, rule *SamplingRule, now time.Time) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	counter, ok := this.counters[group]
+	if !ok || now.Sub(counter.start) >= rule.Interval {
+		counter = &samplingCounter{start: now}
+		this.counters[group] = counter
+	}
+	counter.count++
+	if counter.count <= rule.First {
+		return false
+	}
+	if rule.Thereafter > 0 && (counter.count-rule.First)%rule.Thereafter == 0 {
+		return false
+	}
+	this.dropped[group]++
+	return true
+}
+
+func (this *sampler) summary(interval time.Duration) {
+	defer this.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			this.report()
+		case <-this.done:
+			this.report()
+			return
+		}
+	}
+}
+
+// report 输出周期内丢弃的条数，并清理过期的计数器
+func (this *sampler) report() {
+	this.lock.Lock()
+	dropped := this.dropped
+	this.dropped = make(map[string]uint64)
+	now := time.Now()
+	for group, counter := range this.counters {
+		if now.Sub(counter.start) > time.Hour {
+			delete(this.counters, group)
+		}
+	}
+	this.lock.Unlock()
+
+	if len(dropped) == 0 {
+		return
+	}
+	total := uint64(0)
+	for _, count := range dropped {
+		total += count
+	}
+	logrus.WithFields(logrus.Fields{"@type": samplingType, "dropped": dropped, "total": total}).Warn("log entries dropped by sampling")
+}
+
+func (this *sampler) stop() {
+	close(this.done)
+	this.wg.Wait()
+}
@@ -0,0 +1,425 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	SinkStdout = "stdout"
+	SinkStderr = "stderr"
+	SinkFile   = "file"
+	SinkSyslog = "syslog"
+	SinkTCP    = "tcp"
+	SinkUDP    = "udp"
+
+	FormatJSON = "json"
+	FormatText = "text"
+
+	// DropNewest 缓冲满时丢弃新日志
+	DropNewest = "newest"
+	// DropOldest 缓冲满时丢弃最早的日志
+	DropOldest = "oldest"
+	// DropNone 缓冲满时阻塞等待
+	DropNone = "none"
+
+	defaultAsyncBufferSize = 1024
+	defaultDialTimeout     = 3 * time.Second
+	// 连接失败后的重连间隔，连续失败时翻倍
+	minRedialInterval = time.Second
+	maxRedialInterval = 30 * time.Second
+)
+
+// SinkConfig 日志输出配置
+type SinkConfig struct {
+	// stdout, stderr, file, syslog, tcp, udp
+	Type string
+
+	// 该输出的最低日志级别，为空时使用Config.Level
+	Level string
+
+	// 非必需，只输出指定的级别，如只输出error的文件，设置后忽略Level
+	Levels []string
+
+	// 非必需，只输出@type为指定值的日志，如access、mysql、sqlite
+	Types []string
+
+	// 非必需，不输出@type为指定值的日志
+	ExcludeTypes []string
+
+	// json或text，默认json，text一般用于开发环境输出到终端
+	Format string
+
+	// file使用，为空时使用Config中的配置
+	LogFile      string
+	MaxRemainCnt int
+	RotationTime time.Duration
+
+	// file使用，单个文件的最大字节数，超过后切割
+	MaxSize int64
+	// file使用，当前文件与切割出的文件的最大总字节数，超过后删除最早的文件
+	MaxTotalSize int64
+	// file使用，gzip压缩切割出的文件
+	Compress bool
+
+	// syslog、tcp、udp使用，syslog为空时连接本地syslog
+	Address string
+	// syslog的tag
+	Tag string
+
+	// 异步写入，写入慢的输出（如网络）建议开启
+	Async bool
+	// 异步缓冲的日志条数，默认1024
+	BufferSize int
+	// 缓冲满时的处理方式，newest、oldest、none，默认newest
+	DropPolicy string
+}
+
+// sinkWriter 日志输出的底层写入
+type sinkWriter interface {
+	WriteLevel(level logrus.Level, p []byte) error
+	// Flush 模块停止时调用，此后仍可能有日志写入
+	Flush() error
+}
+
+// sinkHook 按级别与格式将日志写入sinkWriter
+// 未单独配置级别的输出跟随运行时的生效级别，配置了级别的输出只按自身级别过滤
+type sinkHook struct {
+	explicit     bool
+	levels       map[logrus.Level]bool
+	types        map[string]bool
+	excludeTypes map[string]bool
+	formatter    logrus.Formatter
+	writer       sinkWriter
+}
+
+func (this *sinkHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (this *sinkHook) Fire(e *logrus.Entry) error {
+	if _, ok := e.Data[samplingDropKey]; ok {
+		return nil
+	}
+	if this.explicit {
+		if !this.levels[e.Level] {
+			return nil
+		}
+	} else if _, ok := e.Data[levelDropKey]; ok {
+		return nil
+	}
+	if len(this.types) > 0 || len(this.excludeTypes) > 0 {
+		tp, _ := e.Data["@type"].(string)
+		if (len(this.types) > 0 && !this.types[tp]) || this.excludeTypes[tp] {
+			return nil
+		}
+	}
+	bytes, err := this.formatter.Format(e)
+	if err != nil {
+		return err
+	}
+	return this.writer.WriteLevel(e.Level, bytes)
+}
+
+// newSinkHook 根据配置生成输出
+func (this *LoggerModule) newSinkHook(config *SinkConfig) (*sinkHook, error) {
+	var err error
+	levels := map[logrus.Level]bool{}
+	if len(config.Levels) > 0 {
+		for _, levelStr := range config.Levels {
+			level, err := logrus.ParseLevel(levelStr)
+			if err != nil {
+				return nil, err
+			}
+			levels[level] = true
+		}
+	} else if len(config.Level) > 0 {
+		level, err := logrus.ParseLevel(config.Level)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range logrus.AllLevels[:level+1] {
+			levels[l] = true
+		}
+	}
+
+	formatter := this.formatter
+	if config.Format == FormatText {
+		formatter = &logrus.TextFormatter{TimestampFormat: this.config.TimeFormat, FullTimestamp: true}
+	}
+
+	var writer sinkWriter
+	switch config.Type {
+	case SinkStdout:
+		writer = &lockedWriter{writer: os.Stdout}
+	case SinkStderr:
+		writer = &lockedWriter{writer: os.Stderr}
+	case SinkFile:
+		writer, err = this.newFileWriter(config)
+	case SinkSyslog:
+		writer, err = newSyslogWriter(config)
+	case SinkTCP, SinkUDP:
+		writer = &netWriter{network: config.Type, address: config.Address}
+	default:
+		err = fmt.Errorf("unknown log sink type %v", config.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if config.Async {
+		writer = newAsyncWriter(writer, config.BufferSize, config.DropPolicy)
+	}
+
+	return &sinkHook{
+		explicit:     len(levels) > 0,
+		levels:       levels,
+		types:        toSet(config.Types),
+		excludeTypes: toSet(config.ExcludeTypes),
+		formatter:    formatter,
+		writer:       writer,
+	}, nil
+}
+
+// maxLevel 该输出中最详细的级别
+func (this *sinkHook) maxLevel() logrus.Level {
+	max := logrus.PanicLevel
+	for level := range this.levels {
+		if level > max {
+			max = level
+		}
+	}
+	return max
+}
+
+func toSet(list []string) map[string]bool {
+	if len(list) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(list))
+	for _, item := range list {
+		set[item] = true
+	}
+	return set
+}
+
+func (this *LoggerModule) newFileWriter(config *SinkConfig) (sinkWriter, error) {
+	logFile := config.LogFile
+	if len(logFile) == 0 {
+		logFile = this.config.LogFile
+	}
+	// 未设置时使用全局配置，全局也未配置文件时使用与SetConfig相同的默认值
+	rotationTime := config.RotationTime
+	if rotationTime < time.Minute {
+		rotationTime = this.config.RotationTime
+	}
+	if rotationTime < time.Minute {
+		rotationTime = defaultRotationTime
+	}
+	maxRemainCnt := config.MaxRemainCnt
+	if maxRemainCnt < 1 {
+		maxRemainCnt = this.config.MaxRemainCnt
+	}
+	if maxRemainCnt < 1 {
+		maxRemainCnt = defaultMaxRemainCnt
+	}
+	if config.MaxSize > 0 || config.MaxTotalSize > 0 || config.Compress {
+		writer, err := newRotateWriter(logFile, rotationTime, config.MaxSize, maxRemainCnt, config.MaxTotalSize, config.Compress)
+		if err != nil {
+			logrus.Errorf("config local file system for logger error: %v", err)
+			return nil, err
+		}
+		return &lockedWriter{writer: writer}, nil
+	}
+	writer, err := rotatelogs.New(
+		logFile+".%Y%m%d%H",
+		rotatelogs.WithLinkName(logFile),
+
+		rotatelogs.WithRotationTime(rotationTime),
+
+		//rotatelogs.WithMaxAge(time.Hour*24),
+		rotatelogs.WithRotationCount(uint(maxRemainCnt)),
+	)
+	if err != nil {
+		logrus.Errorf("config local file system for logger error: %v", err)
+		return nil, err
+	}
+	return &lockedWriter{writer: writer}, nil
+}
+
+// lockedWriter 普通io.Writer的输出
+type lockedWriter struct {
+	writer io.Writer
+	lock   sync.Mutex
+}
+
+func (this *lockedWriter) WriteLevel(level logrus.Level, p []byte) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	_, err := this.writer.Write(p)
+	return err
+}
+
+func (this *lockedWriter) Flush() error {
+	return nil
+}
+
+// errNetDisconnected 等待重连期间丢弃日志
+var errNetDisconnected = errors.New("log collector disconnected")
+
+// netWriter tcp/udp输出，连接断开后在下一次写入时重连，连接失败后的重连间隔内直接丢弃日志
+type netWriter struct {
+	network  string
+	address  string
+	conn     net.Conn
+	lock     sync.Mutex
+	redialAt time.Time
+	interval time.Duration
+}
+
+func (this *netWriter) WriteLevel(level logrus.Level, p []byte) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.conn == nil {
+		if time.Now().Before(this.redialAt) {
+			return errNetDisconnected
+		}
+		conn, err := net.DialTimeout(this.network, this.address, defaultDialTimeout)
+		if err != nil {
+			this.backoff()
+			return err
+		}
+		this.conn = conn
+		this.interval = 0
+	}
+	this.conn.SetWriteDeadline(time.Now().Add(defaultDialTimeout))
+	if _, err := this.conn.Write(p); err != nil {
+		this.conn.Close()
+		this.conn = nil
+		this.backoff()
+		return err
+	}
+	return nil
+}
+
+// backoff 连接失败后延后下一次重连
+func (this *netWriter) backoff() {
+	if this.interval == 0 {
+		this.interval = minRedialInterval
+	} else if this.interval < maxRedialInterval {
+		this.interval *= 2
+		if this.interval > maxRedialInterval {
+			this.interval = maxRedialInterval
+		}
+	}
+	this.redialAt = time.Now().Add(this.interval)
+}
+
+func (this *netWriter) Flush() error {
+	return nil
+}
+
+type asyncEntry struct {
+	level logrus.Level
+	data  []byte
+}
+
+// asyncWriter 异步写入，缓冲满时按dropPolicy处理，Flush之后改为同步写入
+type asyncWriter struct {
+	// 放在首位以保证32位平台上原子操作的对齐
+	dropped    uint64
+	writer     sinkWriter
+	dropPolicy string
+	entries    chan *asyncEntry
+	done       chan struct{}
+	flushed    bool
+	lock       sync.RWMutex
+}
+
+func newAsyncWriter(writer sinkWriter, bufferSize int, dropPolicy string) *asyncWriter {
+	if bufferSize < 1 {
+		bufferSize = defaultAsyncBufferSize
+	}
+	if len(dropPolicy) == 0 {
+		dropPolicy = DropNewest
+	}
+	async := &asyncWriter{
+		writer:     writer,
+		dropPolicy: dropPolicy,
+		entries:    make(chan *asyncEntry, bufferSize),
+		done:       make(chan struct{}),
+	}
+	go async.run()
+	return async
+}
+
+func (this *asyncWriter) WriteLevel(level logrus.Level, p []byte) error {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if this.flushed {
+		return this.writer.WriteLevel(level, p)
+	}
+	// formatter复用了buffer，需要拷贝
+	entry := &asyncEntry{level: level, data: append([]byte(nil), p...)}
+	switch this.dropPolicy {
+	case DropNone:
+		this.entries <- entry
+	case DropOldest:
+		for {
+			select {
+			case this.entries <- entry:
+				return nil
+			default:
+			}
+			select {
+			case <-this.entries:
+				atomic.AddUint64(&this.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case this.entries <- entry:
+		default:
+			atomic.AddUint64(&this.dropped, 1)
+		}
+	}
+	return nil
+}
+
+func (this *asyncWriter) run() {
+	defer close(this.done)
+	for entry := range this.entries {
+		if err := this.writer.WriteLevel(entry.level, entry.data); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write log: %v\n", err)
+		}
+	}
+}
+
+// Dropped 因缓冲满被丢弃的日志条数
+func (this *asyncWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&this.dropped)
+}
+
+// Flush 写完缓冲中的日志，之后的日志同步写入
+func (this *asyncWriter) Flush() error {
+	this.lock.Lock()
+	if !this.flushed {
+		this.flushed = true
+		close(this.entries)
+	}
+	this.lock.Unlock()
+	<-this.done
+	if dropped := this.Dropped(); dropped > 0 {
+		fmt.Fprintf(os.Stderr, "async logger dropped %d entries\n", dropped)
+	}
+	return this.writer.Flush()
+}
@@ -0,0 +1,54 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logger
+
+import (
+	"log/syslog"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// syslogWriter 按日志级别写入对应的syslog优先级
+type syslogWriter struct {
+	writer *syslog.Writer
+}
+
+// newSyslogWriter Address格式为network://host:port，为空时连接本地syslog
+func newSyslogWriter(config *SinkConfig) (sinkWriter, error) {
+	network, raddr := "", ""
+	if len(config.Address) > 0 {
+		network, raddr = "udp", config.Address
+		if idx := strings.Index(config.Address, "://"); idx > 0 {
+			network, raddr = config.Address[:idx], config.Address[idx+3:]
+		}
+	}
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_USER, config.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogWriter{writer: writer}, nil
+}
+
+func (this *syslogWriter) WriteLevel(level logrus.Level, p []byte) error {
+	msg := string(p)
+	switch level {
+	case logrus.PanicLevel:
+		return this.writer.Emerg(msg)
+	case logrus.FatalLevel:
+		return this.writer.Crit(msg)
+	case logrus.ErrorLevel:
+		return this.writer.Err(msg)
+	case logrus.WarnLevel:
+		return this.writer.Warning(msg)
+	case logrus.InfoLevel:
+		return this.writer.Info(msg)
+	default:
+		return this.writer.Debug(msg)
+	}
+}
+
+func (this *syslogWriter) Flush() error {
+	return nil
+}
@@ -2,135 +2,90 @@ package mysql
 
 import (
 	"database/sql"
-	"errors"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql" // register mysql driver
 	"github.com/sayuri567/tool/base/model"
-	"github.com/sayuri567/tool/module"
-	"github.com/sirupsen/logrus"
+	"github.com/sayuri567/tool/module/sqldb"
 	gorp "gopkg.in/gorp.v1"
 )
 
+// MysqlModule MysqlModule.
 type MysqlModule struct {
-	*module.DefaultModule
-	modelMap      map[string][]modelMapItem
-	callbacks     []func()
-	enableDbTrace bool
-	inited        bool
-	connStrGetter dbConnectionStringGetter
+	*sqldb.SqlModule
 }
 
-type modelMapItem struct {
-	model model.Model
-	obj   interface{}
-}
-
-// DbConnectionStringGetter DbConnectionStringGetter.
-type dbConnectionStringGetter interface {
-	GetDbConnectionString(dbKey string) string
+// defaultPoolConfig 默认连接池配置
+var defaultPoolConfig = &model.PoolConfig{
+	MaxIdleConns:    10,
+	MaxOpenConns:    100,
+	ConnMaxLifetime: 200 * time.Second,
 }
 
 // 单例
 var mysqlModule = &MysqlModule{
-	modelMap:  make(map[string][]modelMapItem),
-	callbacks: make([]func(), 0),
-	inited:    false,
+	SqlModule: sqldb.NewSqlModule("mysql", "mysql", gorp.MySQLDialect{}, defaultPoolConfig),
 }
 
 func GetMysqlModule() *MysqlModule {
 	return mysqlModule
 }
 
-func SetConnStrGetter(getter dbConnectionStringGetter) {
-	mysqlModule.connStrGetter = getter
-}
-
-func (this *MysqlModule) Init() error {
-	if this.connStrGetter == nil {
-		return errors.New("connStrGetter not set")
-	}
-	for dbKey, mapItems := range this.modelMap {
-		db, err := sql.Open("mysql", this.connStrGetter.GetDbConnectionString(dbKey))
-		if err != nil {
-			return err
-		}
-		err = db.Ping()
-		if err != nil {
-			return err
-		}
-		db.SetMaxIdleConns(10)
-		db.SetMaxOpenConns(100)
-		db.SetConnMaxLifetime(200 * time.Second)
-		dbMap := &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{}}
-		if this.enableDbTrace {
-			dbMap.TraceOn("", &dbLogger{})
-		}
-		for _, mi := range mapItems {
-			mi.model.SetDbMap(dbMap)
-			mi.model.SetDb(db)
-			err = mi.model.Initer(dbMap, mi.obj, mi.model.GetTable())
-			if err != nil {
-				return err
-			}
-			mi.model.SetModel(mi.model)
-			mi.model.SetFields(model.GetAllFieldsAsString(mi.obj))
-		}
-	}
-
-	this.inited = true
-	for _, callback := range this.callbacks {
-		callback()
-	}
-
-	logrus.Info("mysql module inited")
-	return nil
-}
-
-func (this *MysqlModule) Stop() {
-	logrus.Info("Stopping mysql connects")
-	for _, mapItems := range this.modelMap {
-		for _, mi := range mapItems {
-			err := mi.model.Db().Close()
-			if err != nil {
-				logrus.Error(err.Error())
-			}
-			break
-		}
-	}
-	logrus.Info("Stopped mysql connects")
+func SetConnStrGetter(getter sqldb.DbConnectionStringGetter) {
+	mysqlModule.SetConnStrGetter(getter)
+}
+
+// SetReplicaOptions 设置从库健康检查等配置，从库地址由connStrGetter实现sqldb.DbReplicaConnectionStringGetter提供
+func SetReplicaOptions(options *model.ReplicaOptions) {
+	mysqlModule.SetReplicaOptions(options)
+}
+
+// SetPoolMonitor 每隔interval输出一次连接池状态，使用中的连接数达到最大连接数的saturation比例（默认0.8）或出现等待时输出警告，需在Init之前调用
+func SetPoolMonitor(interval time.Duration, saturation float64) {
+	mysqlModule.SetPoolMonitor(interval, saturation)
+}
+
+// SetDbTrace 输出所有执行的sql，需在Init之前调用
+func SetDbTrace(enable bool) {
+	mysqlModule.SetDbTrace(enable)
+}
+
+// SetObserver 记录dbKey执行的sql的耗时、影响行数与错误，慢sql输出warn日志，dbKey为空时作用于未单独设置的所有dbKey，需在Init之前调用
+func SetObserver(dbKey string, config *sqldb.ObserverConfig) {
+	mysqlModule.SetObserver(dbKey, config)
+}
+
+// SetMigrations 设置dbKey对应数据库的迁移，options.AutoRun为true时在Init中执行所有未执行的迁移
+func SetMigrations(dbKey string, options *model.MigrateOptions) {
+	mysqlModule.SetMigrations(dbKey, options)
 }
 
 // Register Register.
 func Register(dbKey string, model model.Model, obj interface{}) {
-	mapItems, ok := mysqlModule.modelMap[dbKey]
-	if ok {
-		for _, mi := range mapItems {
-			if model == mi.model {
-				return
-			}
-			mysqlModule.modelMap[dbKey] = append(mapItems, modelMapItem{model: model, obj: obj})
-		}
-	} else {
-		mapItems := make([]modelMapItem, 0, 5)
-		mysqlModule.modelMap[dbKey] = append(mapItems, modelMapItem{model: model, obj: obj})
-	}
+	mysqlModule.Register(dbKey, model, obj)
 }
 
 // RegisterCallback RegisterCallback.
 func RegisterCallback(callback func()) {
-	if mysqlModule.inited {
-		callback()
-		return
-	}
-	mysqlModule.callbacks = append(mysqlModule.callbacks, callback)
+	mysqlModule.RegisterCallback(callback)
+}
+
+// Transaction 在dbKey对应数据库的事务中执行fn，fn中通过tx.Model获取绑定到事务的model
+func Transaction(dbKey string, fn func(tx *model.Tx) error, options ...*model.TxOptions) error {
+	return mysqlModule.Transaction(dbKey, fn, options...)
+}
+
+// GetMigrator 获取dbKey对应数据库的迁移，需在Init之后调用
+func GetMigrator(dbKey string) (*model.Migrator, error) {
+	return mysqlModule.GetMigrator(dbKey)
 }
 
-// DbLogger DbLogger.
-type dbLogger struct {
+// GetDbStats 获取dbKey的连接池状态
+func GetDbStats(dbKey string) (sql.DBStats, error) {
+	return mysqlModule.GetDbStats(dbKey)
 }
 
-// Printf Printf.
-func (this *dbLogger) Printf(format string, v ...interface{}) {
-	logrus.WithFields(logrus.Fields{"@type": "mysql"}).Infof(format, v...)
+// GetAllDbStats 获取所有连接的连接池状态，key为dbKey，从库为dbKey/replica{index}
+func GetAllDbStats() map[string]sql.DBStats {
+	return mysqlModule.GetAllDbStats()
 }
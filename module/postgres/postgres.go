@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/lib/pq" // register postgres driver
+	"github.com/sayuri567/tool/base/model"
+	"github.com/sayuri567/tool/module/sqldb"
+	gorp "gopkg.in/gorp.v1"
+)
+
+// PostgresModule PostgresModule.
+type PostgresModule struct {
+	*sqldb.SqlModule
+}
+
+// defaultPoolConfig 默认连接池配置
+var defaultPoolConfig = &model.PoolConfig{
+	MaxIdleConns:    10,
+	MaxOpenConns:    100,
+	ConnMaxLifetime: 200 * time.Second,
+}
+
+// 单例
+var postgresModule = &PostgresModule{
+	SqlModule: sqldb.NewSqlModule("postgres", "postgres", gorp.PostgresDialect{}, defaultPoolConfig),
+}
+
+func GetPostgresModule() *PostgresModule {
+	return postgresModule
+}
+
+func SetConnStrGetter(getter sqldb.DbConnectionStringGetter) {
+	postgresModule.SetConnStrGetter(getter)
+}
+
+// SetReplicaOptions 设置从库健康检查等配置，从库地址由connStrGetter实现sqldb.DbReplicaConnectionStringGetter提供
+func SetReplicaOptions(options *model.ReplicaOptions) {
+	postgresModule.SetReplicaOptions(options)
+}
+
+// SetPoolMonitor 每隔interval输出一次连接池状态，使用中的连接数达到最大连接数的saturation比例（默认0.8）或出现等待时输出警告，需在Init之前调用
+func SetPoolMonitor(interval time.Duration, saturation float64) {
+	postgresModule.SetPoolMonitor(interval, saturation)
+}
+
+// SetDbTrace 输出所有执行的sql，需在Init之前调用
+func SetDbTrace(enable bool) {
+	postgresModule.SetDbTrace(enable)
+}
+
+// SetObserver 记录dbKey执行的sql的耗时、影响行数与错误，慢sql输出warn日志，dbKey为空时作用于未单独设置的所有dbKey，需在Init之前调用
+func SetObserver(dbKey string, config *sqldb.ObserverConfig) {
+	postgresModule.SetObserver(dbKey, config)
+}
+
+// SetMigrations 设置dbKey对应数据库的迁移，options.AutoRun为true时在Init中执行所有未执行的迁移
+func SetMigrations(dbKey string, options *model.MigrateOptions) {
+	postgresModule.SetMigrations(dbKey, options)
+}
+
+// Register Register.
+func Register(dbKey string, model model.Model, obj interface{}) {
+	postgresModule.Register(dbKey, model, obj)
+}
+
+// RegisterCallback RegisterCallback.
+func RegisterCallback(callback func()) {
+	postgresModule.RegisterCallback(callback)
+}
+
+// Transaction 在dbKey对应数据库的事务中执行fn，fn中通过tx.Model获取绑定到事务的model
+func Transaction(dbKey string, fn func(tx *model.Tx) error, options ...*model.TxOptions) error {
+	return postgresModule.Transaction(dbKey, fn, options...)
+}
+
+// GetMigrator 获取dbKey对应数据库的迁移，需在Init之后调用
+func GetMigrator(dbKey string) (*model.Migrator, error) {
+	return postgresModule.GetMigrator(dbKey)
+}
+
+// GetDbStats 获取dbKey的连接池状态
+func GetDbStats(dbKey string) (sql.DBStats, error) {
+	return postgresModule.GetDbStats(dbKey)
+}
+
+// GetAllDbStats 获取所有连接的连接池状态，key为dbKey，从库为dbKey/replica{index}
+func GetAllDbStats() map[string]sql.DBStats {
+	return postgresModule.GetAllDbStats()
+}
@@ -1,11 +1,13 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
 	"reflect"
 
 	"github.com/adjust/rmq/v4"
 	"github.com/sayuri567/gorun"
+	"github.com/sayuri567/tool/module/logger"
 	"github.com/sirupsen/logrus"
 )
 
@@ -19,6 +21,11 @@ type Job interface {
 	Program() error
 }
 
+// ContextJob job可选实现的接口，在Program之前传入携带日志的context，通过logger.FromContext获取日志
+type ContextJob interface {
+	SetContext(ctx context.Context)
+}
+
 // BaseJob BaseJob
 type BaseJob struct {
 	job  Job
@@ -39,18 +46,22 @@ func (j *BaseJob) Consume(delivery rmq.Delivery) {
 	var isRejected = false
 	var err error
 	var param = reflect.New(reflect.TypeOf(j.job).Elem())
+	var entry = logrus.WithField("job", j.name)
 	err = json.Unmarshal([]byte(delivery.Payload()), param.Interface())
 	if err != nil {
-		logrus.WithError(err).WithField("job", j.name).Error("failed to parse json message")
+		entry.WithError(err).Error("failed to parse json message")
 		delivery.Reject()
 		isRejected = true
 		return
 	}
+	if contextJob, ok := param.Interface().(ContextJob); ok {
+		contextJob.SetContext(logger.NewContext(context.Background(), entry))
+	}
 	before := param.MethodByName("Before")
 	if before.IsValid() && !before.IsNil() && before.Kind() == reflect.Func {
 		beforeErr := before.Call(nil)
 		if beforeErr != nil && len(beforeErr) > 0 && !beforeErr[0].IsNil() {
-			logrus.WithField("error", beforeErr[0].Interface()).WithField("job", j.name).Error("run job before function has error")
+			entry.WithField("error", beforeErr[0].Interface()).Error("run job before function has error")
 			// 执行准备工作方法时出错，驳回消息，尝试重试
 			delivery.Reject()
 			isRejected = true
@@ -59,7 +70,7 @@ func (j *BaseJob) Consume(delivery rmq.Delivery) {
 	}
 	program := param.MethodByName("Program")
 	if program.Kind() != reflect.Func {
-		logrus.WithError(err).WithField("job", j.name).Error("job has no program function")
+		entry.Error("job has no program function")
 		// 找不到Program方法，驳回消息
 		delivery.Reject()
 		isRejected = true
@@ -67,7 +78,7 @@ func (j *BaseJob) Consume(delivery rmq.Delivery) {
 	}
 	programErr := program.Call(nil)
 	if programErr != nil && len(programErr) > 0 && !programErr[0].IsNil() {
-		logrus.WithField("error", programErr[0].Interface()).WithField("job", j.name).Error("run job program function has error")
+		entry.WithField("error", programErr[0].Interface()).Error("run job program function has error")
 		// 执行Program出错，驳回消息，并执行after方法
 		delivery.Reject()
 		isRejected = true
@@ -78,7 +89,7 @@ func (j *BaseJob) Consume(delivery rmq.Delivery) {
 		afterErr := after.Call(nil)
 		if afterErr != nil && len(afterErr) > 0 && !afterErr[0].IsNil() {
 			// 执行After出错，驳回消息，并执行after方法
-			logrus.WithField("error", afterErr[0].Interface()).WithField("job", j.name).Error("run job after function has error")
+			entry.WithField("error", afterErr[0].Interface()).Error("run job after function has error")
 		}
 	}
 
@@ -0,0 +1,126 @@
+package redispool
+
+import (
+	"fmt"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// Cache 基于连接池的cache.Store，可用于api的接口缓存与model的按id缓存.
+// 标签以set的形式保存，过期时间延长到其中最晚过期的缓存，在InvalidateTags时删除
+// e.g.
+//
+//	configModel.SetCache(&model.CacheOptions{Store: redispool.NewCache("", "model:cache:")})
+type Cache struct {
+	poolName string
+	prefix   string
+}
+
+// NewCache poolName为注册的名称，为空时使用默认连接池，prefix默认为"cache:"
+func NewCache(poolName string, prefix string) *Cache {
+	if len(prefix) == 0 {
+		prefix = "cache:"
+	}
+	return &Cache{poolName: poolName, prefix: prefix}
+}
+
+func (this *Cache) conn() (redigo.Conn, error) {
+	if len(this.poolName) == 0 {
+		return Get(), nil
+	}
+	if conn := GetConn(this.poolName); conn != nil {
+		return conn, nil
+	}
+	return nil, fmt.Errorf("unknown redis pool %v", this.poolName)
+}
+
+func (this *Cache) Get(key string) ([]byte, bool, error) {
+	conn, err := this.conn()
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+	value, err := redigo.Bytes(conn.Do("GET", this.prefix+key))
+	if err == redigo.ErrNil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (this *Cache) Set(key string, value []byte, ttl time.Duration, tags ...string) error {
+	conn, err := this.conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if len(tags) == 0 {
+		_, err = conn.Do("SET", this.prefix+key, value, "PX", int64(ttl/time.Millisecond))
+		return err
+	}
+	args := redigo.Args{}.Add(len(tags)+1, this.prefix+key)
+	for _, tag := range tags {
+		args = args.Add(this.tagKey(tag))
+	}
+	args = args.Add(value, int64(ttl/time.Millisecond))
+	_, err = setWithTagsScript.Do(conn, args...)
+	return err
+}
+
+// setWithTagsScript 调用时第一个参数为key的数量，KEYS[1]为缓存key，其余为标签key，ARGV为缓存的值与毫秒过期时间
+// 标签的过期时间只延长不缩短，保证标签不早于其中的缓存过期
+var setWithTagsScript = redigo.NewScript(-1, `
+local ttl = tonumber(ARGV[2])
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ttl)
+for i = 2, #KEYS do
+	redis.call('SADD', KEYS[i], KEYS[1])
+	if redis.call('PTTL', KEYS[i]) < ttl then
+		redis.call('PEXPIRE', KEYS[i], ttl)
+	end
+end
+return 1
+`)
+
+func (this *Cache) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	conn, err := this.conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	args := make(redigo.Args, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, this.prefix+key)
+	}
+	_, err = conn.Do("DEL", args...)
+	return err
+}
+
+func (this *Cache) InvalidateTags(tags ...string) error {
+	conn, err := this.conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, tag := range tags {
+		tagKey := this.tagKey(tag)
+		keys, err := redigo.Strings(conn.Do("SMEMBERS", tagKey))
+		if err != nil {
+			return err
+		}
+		args := redigo.Args{}.Add(tagKey).AddFlat(keys)
+		if _, err = conn.Do("DEL", args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *Cache) tagKey(tag string) string {
+	return this.prefix + "tag:" + tag
+}
@@ -0,0 +1,360 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSlowThreshold = time.Second
+	redactedArg          = "***"
+)
+
+// eventPool Hooks不为空时每条sql都会生成SqlEvent，复用以减少分配
+var eventPool = sync.Pool{New: func() interface{} { return &SqlEvent{} }}
+
+// SqlEvent 一次sql执行的记录
+type SqlEvent struct {
+	DbKey     string
+	Statement string
+	// 脱敏后的参数
+	Args     []interface{}
+	Duration time.Duration
+	// 影响的行数，查询语句为-1
+	RowsAffected int64
+	Err          error
+	Slow         bool
+	Time         time.Time
+
+	// 复用的参数缓冲
+	buf []interface{}
+}
+
+// SqlHook 自定义的sql记录导出，如上报到监控或链路追踪系统.
+// event与其中的Args在OnSql返回后会被复用，需要异步处理时自行复制
+type SqlHook interface {
+	OnSql(event *SqlEvent)
+}
+
+// SqlHookFunc 函数形式的SqlHook
+type SqlHookFunc func(event *SqlEvent)
+
+// OnSql OnSql.
+func (this SqlHookFunc) OnSql(event *SqlEvent) {
+	this(event)
+}
+
+// ObserverConfig sql观察配置
+type ObserverConfig struct {
+	// 执行时间超过该值时输出warn日志，默认1s，小于0时不输出
+	SlowThreshold time.Duration
+	// 未超时的sql按该比例输出完整日志，0~1，默认不输出
+	TraceSampleRate float64
+	// 输出原始参数，默认所有参数替换为***
+	ShowArgs bool
+	// 自定义参数脱敏，设置后忽略ShowArgs，需要保留部分参数（如id）并隐藏其他参数时使用.
+	// args在调用后会被复用，可直接修改后返回，不能保留
+	RedactArgs func(args []interface{}) []interface{}
+	// 自定义导出，每条sql均会调用，需自行处理并发
+	Hooks []SqlHook
+}
+
+// observer 记录sql的执行时间、影响行数与错误
+type observer struct {
+	dbKey  string
+	tp     string
+	config *ObserverConfig
+}
+
+func newObserver(dbKey string, tp string, config *ObserverConfig) *observer {
+	merged := *config
+	if merged.SlowThreshold == 0 {
+		merged.SlowThreshold = defaultSlowThreshold
+	}
+	return &observer{dbKey: dbKey, tp: tp, config: &merged}
+}
+
+func (this *observer) observe(query string, args []driver.NamedValue, start time.Time, rows int64, err error) {
+	if errors.Is(err, driver.ErrSkip) {
+		return
+	}
+	duration := time.Since(start)
+	slow := this.config.SlowThreshold > 0 && duration >= this.config.SlowThreshold
+	trace := this.config.TraceSampleRate > 0 && rand.Float64() < this.config.TraceSampleRate
+	if !slow && !trace && err == nil && len(this.config.Hooks) == 0 {
+		return
+	}
+	event := eventPool.Get().(*SqlEvent)
+	event.DbKey = this.dbKey
+	event.Statement = query
+	event.Args = this.redact(args, event)
+	event.Duration = duration
+	event.RowsAffected = rows
+	event.Err = err
+	event.Slow = slow
+	event.Time = start
+	for _, hook := range this.config.Hooks {
+		hook.OnSql(event)
+	}
+	if slow || trace || err != nil {
+		this.log(event)
+	}
+	buf := event.buf
+	*event = SqlEvent{buf: buf[:0]}
+	eventPool.Put(event)
+}
+
+func (this *observer) log(event *SqlEvent) {
+	entry := logrus.WithFields(logrus.Fields{
+		"@type":    this.tp,
+		"dbKey":    event.DbKey,
+		"sql":      event.Statement,
+		"args":     append([]interface{}(nil), event.Args...),
+		"duration": float64(event.Duration.Microseconds()) / 1000,
+		"rows":     event.RowsAffected,
+	})
+	switch {
+	case event.Err != nil:
+		entry.WithError(event.Err).Error("sql error")
+	case event.Slow:
+		entry.Warn("slow sql")
+	default:
+		entry.Info("sql trace")
+	}
+}
+
+// redact 未设置ShowArgs与RedactArgs时所有参数替换为***，参数复制到event自己的缓冲中，hook修改Args不影响其他event
+func (this *observer) redact(namedArgs []driver.NamedValue, event *SqlEvent) []interface{} {
+	masked := !this.config.ShowArgs && this.config.RedactArgs == nil
+	args := event.buf[:0]
+	for _, arg := range namedArgs {
+		if masked {
+			args = append(args, redactedArg)
+		} else {
+			args = append(args, arg.Value)
+		}
+	}
+	event.buf = args
+	if masked {
+		return args
+	}
+	if this.config.RedactArgs != nil {
+		return this.config.RedactArgs(args)
+	}
+	return args
+}
+
+type observedConnector struct {
+	connector driver.Connector
+	obs       *observer
+}
+
+func (this *observedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := this.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &observedConn{conn: conn, obs: this.obs}, nil
+}
+
+func (this *observedConnector) Driver() driver.Driver {
+	return this.connector.Driver()
+}
+
+// observedConn 包装驱动的连接，未实现的可选接口返回driver.ErrSkip由database/sql回退
+type observedConn struct {
+	conn driver.Conn
+	obs  *observer
+}
+
+func (this *observedConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := this.conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &observedStmt{stmt: stmt, conn: this.conn, query: query, obs: this.obs}, nil
+}
+
+func (this *observedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	preparer, ok := this.conn.(driver.ConnPrepareContext)
+	if !ok {
+		return this.Prepare(query)
+	}
+	stmt, err := preparer.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &observedStmt{stmt: stmt, conn: this.conn, query: query, obs: this.obs}, nil
+}
+
+func (this *observedConn) Close() error {
+	return this.conn.Close()
+}
+
+func (this *observedConn) Begin() (driver.Tx, error) {
+	return this.conn.Begin() //nolint:staticcheck
+}
+
+func (this *observedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := this.conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) || opts.ReadOnly {
+		return nil, errors.New("sqldb: driver does not support non-default transaction options")
+	}
+	return this.conn.Begin() //nolint:staticcheck
+}
+
+func (this *observedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := this.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	this.obs.observe(query, args, start, rowsAffected(result, err), err)
+	return result, err
+}
+
+func (this *observedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := this.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	this.obs.observe(query, args, start, -1, err)
+	return rows, err
+}
+
+func (this *observedConn) Ping(ctx context.Context) error {
+	if pinger, ok := this.conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (this *observedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := this.conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (this *observedConn) IsValid() bool {
+	if validator, ok := this.conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (this *observedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := this.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type observedStmt struct {
+	stmt  driver.Stmt
+	conn  driver.Conn
+	query string
+	obs   *observer
+}
+
+func (this *observedStmt) Close() error {
+	return this.stmt.Close()
+}
+
+func (this *observedStmt) NumInput() int {
+	return this.stmt.NumInput()
+}
+
+func (this *observedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	start := time.Now()
+	result, err := this.stmt.Exec(args) //nolint:staticcheck
+	this.obs.observe(this.query, toNamedValues(args), start, rowsAffected(result, err), err)
+	return result, err
+}
+
+func (this *observedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := this.stmt.Query(args) //nolint:staticcheck
+	this.obs.observe(this.query, toNamedValues(args), start, -1, err)
+	return rows, err
+}
+
+func (this *observedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := this.stmt.(driver.StmtExecContext)
+	if !ok {
+		return this.Exec(toValues(args))
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, args)
+	this.obs.observe(this.query, args, start, rowsAffected(result, err), err)
+	return result, err
+}
+
+func (this *observedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := this.stmt.(driver.StmtQueryContext)
+	if !ok {
+		return this.Query(toValues(args))
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, args)
+	this.obs.observe(this.query, args, start, -1, err)
+	return rows, err
+}
+
+// CheckNamedValue database/sql只使用stmt或conn其中一个的检查，需依次转发
+func (this *observedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := this.stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	if converter, ok := this.stmt.(driver.ColumnConverter); ok { //nolint:staticcheck
+		value, err := converter.ColumnConverter(nv.Ordinal - 1).ConvertValue(nv.Value)
+		if err != nil {
+			return err
+		}
+		nv.Value = value
+		return nil
+	}
+	if checker, ok := this.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func rowsAffected(result driver.Result, err error) int64 {
+	if err != nil || result == nil {
+		return 0
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0
+	}
+	return rows
+}
+
+func toNamedValues(args []driver.Value) []driver.NamedValue {
+	namedArgs := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		namedArgs[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return namedArgs
+}
+
+func toValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
@@ -0,0 +1,375 @@
+package sqldb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sayuri567/tool/base/model"
+	"github.com/sayuri567/tool/module"
+	"github.com/sirupsen/logrus"
+	gorp "gopkg.in/gorp.v1"
+)
+
+// SqlModule 通用的sql数据库模块，mysql、sqlite、postgres模块均基于该模块
+type SqlModule struct {
+	*module.DefaultModule
+	name          string
+	driverName    string
+	dialect       gorp.Dialect
+	defaultPool   *model.PoolConfig
+	modelMap      map[string][]modelMapItem
+	dbMaps        map[string]*gorp.DbMap
+	migrations    map[string]*model.MigrateOptions
+	migrators     map[string]*model.Migrator
+	replicas      map[string]*model.ReplicaSet
+	replicaOption *model.ReplicaOptions
+	observers     map[string]*ObserverConfig
+	callbacks     []func()
+	monitor       *model.PoolMonitor
+	monitorPeriod time.Duration
+	saturation    float64
+	enableDbTrace bool
+	inited        bool
+	createTable   bool
+	connStrGetter DbConnectionStringGetter
+	connStrFilter ConnStrFilter
+}
+
+type modelMapItem struct {
+	model model.Model
+	obj   interface{}
+}
+
+// DbConnectionStringGetter DbConnectionStringGetter.
+type DbConnectionStringGetter interface {
+	GetDbConnectionString(dbKey string) string
+}
+
+// DbReplicaConnectionStringGetter connStrGetter实现该接口时，查询类方法读从库.
+type DbReplicaConnectionStringGetter interface {
+	GetDbReplicaConnectionStrings(dbKey string) []string
+}
+
+// DbPoolConfigGetter connStrGetter实现该接口时，使用返回的连接池配置，返回nil或字段为0时使用默认配置.
+type DbPoolConfigGetter interface {
+	GetDbPoolConfig(dbKey string) *model.PoolConfig
+}
+
+// ConnStrFilter 打开连接前调整连接串与连接池配置，pool为合并默认值后的配置
+type ConnStrFilter func(connStr string, pool *model.PoolConfig) (string, *model.PoolConfig)
+
+type replicaModel interface {
+	SetReplicas(replicas *model.ReplicaSet)
+}
+
+// NewSqlModule name用于日志，driverName为database/sql注册的驱动名，defaultPool为默认连接池配置
+func NewSqlModule(name string, driverName string, dialect gorp.Dialect, defaultPool *model.PoolConfig) *SqlModule {
+	return &SqlModule{
+		name:        name,
+		driverName:  driverName,
+		dialect:     dialect,
+		defaultPool: defaultPool,
+		modelMap:    make(map[string][]modelMapItem),
+		dbMaps:      make(map[string]*gorp.DbMap),
+		migrations:  make(map[string]*model.MigrateOptions),
+		migrators:   make(map[string]*model.Migrator),
+		replicas:    make(map[string]*model.ReplicaSet),
+		observers:   make(map[string]*ObserverConfig),
+		callbacks:   make([]func(), 0),
+		inited:      false,
+		createTable: false,
+	}
+}
+
+// SetConnStrGetter SetConnStrGetter.
+func (this *SqlModule) SetConnStrGetter(getter DbConnectionStringGetter) {
+	this.connStrGetter = getter
+}
+
+// SetConnStrFilter 设置打开连接前对连接串与连接池配置的调整，用于补全驱动相关的默认参数
+func (this *SqlModule) SetConnStrFilter(filter ConnStrFilter) {
+	this.connStrFilter = filter
+}
+
+// SetAutoCreateTable Init时创建不存在的表
+func (this *SqlModule) SetAutoCreateTable() {
+	this.createTable = true
+}
+
+// SetReplicaOptions 设置从库健康检查等配置
+func (this *SqlModule) SetReplicaOptions(options *model.ReplicaOptions) {
+	this.replicaOption = options
+}
+
+// SetPoolMonitor 每隔interval输出一次连接池状态，使用中的连接数达到最大连接数的saturation比例（默认0.8）或出现等待时输出警告，需在Init之前调用
+func (this *SqlModule) SetPoolMonitor(interval time.Duration, saturation float64) {
+	this.monitorPeriod = interval
+	this.saturation = saturation
+}
+
+// SetDbTrace 输出所有执行的sql，需在Init之前调用
+func (this *SqlModule) SetDbTrace(enable bool) {
+	this.enableDbTrace = enable
+}
+
+// SetObserver 记录dbKey（含从库）执行的sql的耗时、影响行数与错误，慢sql输出warn日志，dbKey为空时作用于未单独设置的所有dbKey，需在Init之前调用
+func (this *SqlModule) SetObserver(dbKey string, config *ObserverConfig) {
+	this.observers[dbKey] = config
+}
+
+// SetMigrations 设置dbKey对应数据库的迁移，options.AutoRun为true时在Init中执行所有未执行的迁移
+func (this *SqlModule) SetMigrations(dbKey string, options *model.MigrateOptions) {
+	this.migrations[dbKey] = options
+}
+
+func (this *SqlModule) Init() error {
+	if this.connStrGetter == nil {
+		return errors.New("connStrGetter not set")
+	}
+	for _, dbKey := range this.dbKeys() {
+		mapItems := this.modelMap[dbKey]
+		dbMap, err := this.open(dbKey, dbKey, this.connStrGetter.GetDbConnectionString(dbKey))
+		if err != nil {
+			return err
+		}
+		err = dbMap.Db.Ping()
+		if err != nil {
+			return err
+		}
+		this.dbMaps[dbKey] = dbMap
+		for _, mi := range mapItems {
+			mi.model.SetDbMap(dbMap)
+			mi.model.SetDb(dbMap.Db)
+			err = mi.model.Initer(dbMap, mi.obj, mi.model.GetTable())
+			if err != nil {
+				return err
+			}
+			mi.model.SetModel(mi.model)
+			mi.model.SetFields(model.GetAllFieldsAsString(mi.obj, model.DialectOf(dbMap.Dialect)))
+		}
+		if err = this.initReplicas(dbKey, mapItems); err != nil {
+			return err
+		}
+		if options, ok := this.migrations[dbKey]; ok {
+			migrator, err := model.NewMigrator(dbMap, options)
+			if err != nil {
+				return err
+			}
+			this.migrators[dbKey] = migrator
+			if options.AutoRun {
+				if err = migrator.Up(); err != nil {
+					return err
+				}
+			}
+		}
+		if this.createTable {
+			if err = dbMap.CreateTablesIfNotExists(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if this.monitorPeriod > 0 {
+		this.monitor = model.NewPoolMonitor(this.name+"Pool", this.dbs(), this.monitorPeriod, this.saturation)
+	}
+
+	this.inited = true
+	for _, callback := range this.callbacks {
+		callback()
+	}
+
+	logrus.Info(this.name + " module inited")
+	return nil
+}
+
+// open 打开连接并应用dbKey的连接池配置与sql观察，name为日志中的连接名
+func (this *SqlModule) open(dbKey string, name string, connStr string) (*gorp.DbMap, error) {
+	pool := this.poolConfig(dbKey)
+	if this.connStrFilter != nil {
+		connStr, pool = this.connStrFilter(connStr, pool)
+	}
+	connector, err := model.Connector(this.driverName, connStr)
+	if err != nil {
+		return nil, err
+	}
+	if config := this.observerConfig(dbKey); config != nil {
+		connector = &observedConnector{connector: connector, obs: newObserver(name, this.name+"Sql", config)}
+	}
+	db := sql.OpenDB(model.WrapConnector(connector))
+	pool.Apply(db)
+	dbMap := &gorp.DbMap{Db: db, Dialect: this.dialect}
+	if this.enableDbTrace {
+		dbMap.TraceOn("", &dbLogger{tp: this.name})
+	}
+	return dbMap, nil
+}
+
+// initReplicas 连接dbKey的从库，并设置到该dbKey的所有model中
+func (this *SqlModule) initReplicas(dbKey string, mapItems []modelMapItem) error {
+	getter, ok := this.connStrGetter.(DbReplicaConnectionStringGetter)
+	if !ok {
+		return nil
+	}
+	connStrs := getter.GetDbReplicaConnectionStrings(dbKey)
+	if len(connStrs) == 0 {
+		return nil
+	}
+	dbMaps := make([]*gorp.DbMap, 0, len(connStrs))
+	for i, connStr := range connStrs {
+		dbMap, err := this.open(dbKey, fmt.Sprintf("%v/replica%d", dbKey, i), connStr)
+		if err != nil {
+			return err
+		}
+		for _, mi := range mapItems {
+			if err = mi.model.Initer(dbMap, mi.obj, mi.model.GetTable()); err != nil {
+				return err
+			}
+		}
+		dbMaps = append(dbMaps, dbMap)
+	}
+	replicas := model.NewReplicaSet(dbMaps, this.replicaOption)
+	this.replicas[dbKey] = replicas
+	for _, mi := range mapItems {
+		if m, ok := mi.model.(replicaModel); ok {
+			m.SetReplicas(replicas)
+		}
+	}
+	return nil
+}
+
+// observerConfig dbKey的sql观察配置，未设置时返回nil
+func (this *SqlModule) observerConfig(dbKey string) *ObserverConfig {
+	if config, ok := this.observers[dbKey]; ok {
+		return config
+	}
+	return this.observers[""]
+}
+
+// poolConfig dbKey的连接池配置
+func (this *SqlModule) poolConfig(dbKey string) *model.PoolConfig {
+	if getter, ok := this.connStrGetter.(DbPoolConfigGetter); ok {
+		return getter.GetDbPoolConfig(dbKey).Merge(this.defaultPool)
+	}
+	return this.defaultPool
+}
+
+// dbKeys 注册了model或迁移的dbKey
+func (this *SqlModule) dbKeys() []string {
+	dbKeys := make([]string, 0, len(this.modelMap))
+	for dbKey := range this.modelMap {
+		dbKeys = append(dbKeys, dbKey)
+	}
+	for dbKey := range this.migrations {
+		if _, ok := this.modelMap[dbKey]; !ok {
+			dbKeys = append(dbKeys, dbKey)
+		}
+	}
+	return dbKeys
+}
+
+func (this *SqlModule) Stop() {
+	logrus.Info("Stopping " + this.name + " connects")
+	if this.monitor != nil {
+		this.monitor.Stop()
+	}
+	for _, dbMap := range this.dbMaps {
+		err := dbMap.Db.Close()
+		if err != nil {
+			logrus.Error(err.Error())
+		}
+	}
+	for _, replicas := range this.replicas {
+		replicas.Close()
+	}
+	logrus.Info("Stopped " + this.name + " connects")
+}
+
+// Register Register.
+func (this *SqlModule) Register(dbKey string, model model.Model, obj interface{}) {
+	for _, mi := range this.modelMap[dbKey] {
+		if model == mi.model {
+			return
+		}
+	}
+	this.modelMap[dbKey] = append(this.modelMap[dbKey], modelMapItem{model: model, obj: obj})
+}
+
+// RegisterCallback RegisterCallback.
+func (this *SqlModule) RegisterCallback(callback func()) {
+	if this.inited {
+		callback()
+		return
+	}
+	this.callbacks = append(this.callbacks, callback)
+}
+
+// DbMap 获取dbKey的DbMap，需在Init之后调用
+func (this *SqlModule) DbMap(dbKey string) (*gorp.DbMap, error) {
+	dbMap, ok := this.dbMaps[dbKey]
+	if !ok {
+		return nil, fmt.Errorf("unknown dbKey %v", dbKey)
+	}
+	return dbMap, nil
+}
+
+// Transaction 在dbKey对应数据库的事务中执行fn，fn中通过tx.Model获取绑定到事务的model
+func (this *SqlModule) Transaction(dbKey string, fn func(tx *model.Tx) error, options ...*model.TxOptions) error {
+	dbMap, err := this.DbMap(dbKey)
+	if err != nil {
+		return err
+	}
+	return model.Transaction(dbMap, fn, options...)
+}
+
+// GetMigrator 获取dbKey对应数据库的迁移，需在Init之后调用
+func (this *SqlModule) GetMigrator(dbKey string) (*model.Migrator, error) {
+	migrator, ok := this.migrators[dbKey]
+	if !ok {
+		return nil, fmt.Errorf("migrations of dbKey %v not set", dbKey)
+	}
+	return migrator, nil
+}
+
+// dbs 所有连接，key为dbKey，从库为dbKey/replica{index}
+func (this *SqlModule) dbs() map[string]*sql.DB {
+	dbs := make(map[string]*sql.DB, len(this.dbMaps))
+	for dbKey, dbMap := range this.dbMaps {
+		dbs[dbKey] = dbMap.Db
+	}
+	for dbKey, replicas := range this.replicas {
+		for i, dbMap := range replicas.DbMaps() {
+			dbs[fmt.Sprintf("%v/replica%d", dbKey, i)] = dbMap.Db
+		}
+	}
+	return dbs
+}
+
+// GetDbStats 获取dbKey的连接池状态
+func (this *SqlModule) GetDbStats(dbKey string) (sql.DBStats, error) {
+	dbMap, err := this.DbMap(dbKey)
+	if err != nil {
+		return sql.DBStats{}, err
+	}
+	return dbMap.Db.Stats(), nil
+}
+
+// GetAllDbStats 获取所有连接的连接池状态，key为dbKey，从库为dbKey/replica{index}
+func (this *SqlModule) GetAllDbStats() map[string]sql.DBStats {
+	stats := make(map[string]sql.DBStats)
+	for name, db := range this.dbs() {
+		stats[name] = db.Stats()
+	}
+	return stats
+}
+
+// DbLogger DbLogger.
+type dbLogger struct {
+	tp string
+}
+
+// Printf Printf.
+func (this *dbLogger) Printf(format string, v ...interface{}) {
+	logrus.WithFields(logrus.Fields{"@type": this.tp}).Infof(format, v...)
+}
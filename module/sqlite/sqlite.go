@@ -2,144 +2,126 @@ package sqlite
 
 import (
 	"database/sql"
-	"errors"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3" // register sqlite driver
 	"github.com/sayuri567/tool/base/model"
-	"github.com/sayuri567/tool/module"
-	"github.com/sirupsen/logrus"
+	"github.com/sayuri567/tool/module/sqldb"
 	gorp "gopkg.in/gorp.v1"
 )
 
+// SqliteModule SqliteModule.
 type SqliteModule struct {
-	*module.DefaultModule
-	modelMap      map[string][]modelMapItem
-	callbacks     []func()
-	enableDbTrace bool
-	inited        bool
-	connStrGetter dbConnectionStringGetter
-	createTable   bool
+	*sqldb.SqlModule
 }
 
-type modelMapItem struct {
-	model model.Model
-	obj   interface{}
+// defaultPoolConfig 默认连接池配置，连接串默认开启WAL，读与写可并发，同时只有一个写入，其他写入最多等待busy_timeout
+var defaultPoolConfig = &model.PoolConfig{
+	MaxIdleConns: 4,
+	MaxOpenConns: 4,
 }
 
-// DbConnectionStringGetter DbConnectionStringGetter.
-type dbConnectionStringGetter interface {
-	GetDbConnectionString(dbKey string) string
-}
+// defaultBusyTimeout 连接串未设置_busy_timeout时的默认值，单位毫秒
+const defaultBusyTimeout = "5000"
 
 // 单例
-var sqliteModule = &SqliteModule{
-	modelMap:    make(map[string][]modelMapItem),
-	callbacks:   make([]func(), 0),
-	inited:      false,
-	createTable: false,
+var sqliteModule = newSqliteModule()
+
+func newSqliteModule() *SqliteModule {
+	m := &SqliteModule{
+		SqlModule: sqldb.NewSqlModule("sqlite", "sqlite3", gorp.SqliteDialect{}, defaultPoolConfig),
+	}
+	m.SetConnStrFilter(filterConnStr)
+	return m
+}
+
+// filterConnStr 文件数据库未设置时补全_journal_mode=WAL与_busy_timeout.
+// 内存数据库每个连接是独立的库，固定只使用一个连接，此时事务未结束前不经过事务的调用（未通过tx.Model获取的model）会一直等待连接，
+// 文件数据库中这类调用在事务持有写锁时最多等待busy_timeout后返回database is locked.
+func filterConnStr(connStr string, pool *model.PoolConfig) (string, *model.PoolConfig) {
+	if strings.Contains(connStr, ":memory:") || strings.Contains(connStr, "mode=memory") {
+		single := *pool
+		single.MaxOpenConns, single.MaxIdleConns = 1, 1
+		return connStr, &single
+	}
+	params := make([]string, 0, 2)
+	if !strings.Contains(connStr, "_journal_mode=") && !strings.Contains(connStr, "_journal=") {
+		params = append(params, "_journal_mode=WAL")
+	}
+	if !strings.Contains(connStr, "_busy_timeout=") && !strings.Contains(connStr, "_timeout=") {
+		params = append(params, "_busy_timeout="+defaultBusyTimeout)
+	}
+	if len(params) == 0 {
+		return connStr, pool
+	}
+	sep := "?"
+	if strings.Contains(connStr, "?") {
+		sep = "&"
+	}
+	return connStr + sep + strings.Join(params, "&"), pool
 }
 
 func GetSqliteModule() *SqliteModule {
 	return sqliteModule
 }
 
-func SetConnStrGetter(getter dbConnectionStringGetter) {
-	sqliteModule.connStrGetter = getter
+func SetConnStrGetter(getter sqldb.DbConnectionStringGetter) {
+	sqliteModule.SetConnStrGetter(getter)
 }
 
 func SetAutoCreateTable() {
-	sqliteModule.createTable = true
+	sqliteModule.SetAutoCreateTable()
 }
 
-func (this *SqliteModule) Init() error {
-	if this.connStrGetter == nil {
-		return errors.New("connStrGetter not set")
-	}
-	for dbKey, mapItems := range this.modelMap {
-		db, err := sql.Open("sqlite3", this.connStrGetter.GetDbConnectionString(dbKey))
-		if err != nil {
-			return err
-		}
-		err = db.Ping()
-		if err != nil {
-			return err
-		}
-		db.SetMaxIdleConns(10)
-		db.SetMaxOpenConns(100)
-		db.SetConnMaxLifetime(200 * time.Second)
-		dbMap := &gorp.DbMap{Db: db, Dialect: gorp.SqliteDialect{}}
-		if this.enableDbTrace {
-			dbMap.TraceOn("", &dbLogger{})
-		}
-		for _, mi := range mapItems {
-			mi.model.SetDbMap(dbMap)
-			mi.model.SetDb(db)
-			err = mi.model.Initer(dbMap, mi.obj, mi.model.GetTable())
-			if err != nil {
-				return err
-			}
-			mi.model.SetModel(mi.model)
-			mi.model.SetFields(model.GetAllFieldsAsString(mi.obj))
-		}
-		if this.createTable {
-			dbMap.CreateTablesIfNotExists()
-		}
-	}
+// SetPoolMonitor 每隔interval输出一次连接池状态，使用中的连接数达到最大连接数的saturation比例（默认0.8）或出现等待时输出警告，需在Init之前调用
+func SetPoolMonitor(interval time.Duration, saturation float64) {
+	sqliteModule.SetPoolMonitor(interval, saturation)
+}
 
-	this.inited = true
-	for _, callback := range this.callbacks {
-		callback()
-	}
+// SetDbTrace 输出所有执行的sql，需在Init之前调用
+func SetDbTrace(enable bool) {
+	sqliteModule.SetDbTrace(enable)
+}
 
-	logrus.Info("sqlite module inited")
-	return nil
+// SetObserver 记录dbKey执行的sql的耗时、影响行数与错误，慢sql输出warn日志，dbKey为空时作用于未单独设置的所有dbKey，需在Init之前调用
+func SetObserver(dbKey string, config *sqldb.ObserverConfig) {
+	sqliteModule.SetObserver(dbKey, config)
 }
 
-func (this *SqliteModule) Stop() {
-	logrus.Info("Stopping sqlite connects")
-	for _, mapItems := range this.modelMap {
-		for _, mi := range mapItems {
-			err := mi.model.Db().Close()
-			if err != nil {
-				logrus.Error(err.Error())
-			}
-			break
-		}
-	}
-	logrus.Info("Stopped sqlite connects")
+// SetMigrations 设置dbKey对应数据库的迁移，options.AutoRun为true时在Init中执行所有未执行的迁移
+func SetMigrations(dbKey string, options *model.MigrateOptions) {
+	sqliteModule.SetMigrations(dbKey, options)
 }
 
 // Register Register.
 func Register(dbKey string, model model.Model, obj interface{}) {
-	mapItems, ok := sqliteModule.modelMap[dbKey]
-	if ok {
-		for _, mi := range mapItems {
-			if model == mi.model {
-				return
-			}
-			sqliteModule.modelMap[dbKey] = append(mapItems, modelMapItem{model: model, obj: obj})
-		}
-	} else {
-		mapItems := make([]modelMapItem, 0, 5)
-		sqliteModule.modelMap[dbKey] = append(mapItems, modelMapItem{model: model, obj: obj})
-	}
+	sqliteModule.Register(dbKey, model, obj)
 }
 
 // RegisterCallback RegisterCallback.
 func RegisterCallback(callback func()) {
-	if sqliteModule.inited {
-		callback()
-		return
-	}
-	sqliteModule.callbacks = append(sqliteModule.callbacks, callback)
+	sqliteModule.RegisterCallback(callback)
+}
+
+// Transaction 在dbKey对应数据库的事务中执行fn，fn中通过tx.Model获取绑定到事务的model.
+// 文件数据库中fn内不经过tx的写入会等待事务释放写锁，最多等待busy_timeout后返回database is locked；
+// 内存数据库只有一个连接，fn内不经过tx的任何调用都会一直等待，导致死锁
+func Transaction(dbKey string, fn func(tx *model.Tx) error, options ...*model.TxOptions) error {
+	return sqliteModule.Transaction(dbKey, fn, options...)
+}
+
+// GetMigrator 获取dbKey对应数据库的迁移，需在Init之后调用
+func GetMigrator(dbKey string) (*model.Migrator, error) {
+	return sqliteModule.GetMigrator(dbKey)
 }
 
-// DbLogger DbLogger.
-type dbLogger struct {
+// GetDbStats 获取dbKey的连接池状态
+func GetDbStats(dbKey string) (sql.DBStats, error) {
+	return sqliteModule.GetDbStats(dbKey)
 }
 
-// Printf Printf.
-func (this *dbLogger) Printf(format string, v ...interface{}) {
-	logrus.WithFields(logrus.Fields{"@type": "sqlite"}).Infof(format, v...)
+// GetAllDbStats 获取所有连接的连接池状态，key为dbKey
+func GetAllDbStats() map[string]sql.DBStats {
+	return sqliteModule.GetAllDbStats()
 }
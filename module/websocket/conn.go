@@ -18,6 +18,7 @@ type Conn struct {
 	Data      map[string]interface{}
 
 	conn      *websocket.Conn
+	logger    *logrus.Entry
 	writeChan chan *Context
 	closed    bool
 	done      chan struct{}
@@ -33,6 +34,11 @@ func (this *Conn) GetSessionId() string {
 	return this.sessionId
 }
 
+// Logger 携带sessionId的日志
+func (this *Conn) Logger() *logrus.Entry {
+	return this.logger
+}
+
 func (this *Conn) GetData(key string) interface{} {
 	return this.Data[key]
 }
@@ -64,7 +70,7 @@ func (this *Conn) close(err error) {
 		return
 	}
 	if err != nil {
-		logrus.WithError(err).Error("close websocket connection for error")
+		this.logger.WithError(err).Error("close websocket connection for error")
 	}
 	this.once.Do(func() {
 		this.conn.Close()
@@ -85,7 +91,7 @@ func (this *Conn) read() {
 
 		ctx, err := this.unmarshal(msg)
 		if err != nil {
-			logrus.WithError(err).Error("failed to decode message")
+			this.logger.WithError(err).Error("failed to decode message")
 			continue
 		}
 		ctx.wsMegType = tp
@@ -110,18 +116,18 @@ loop:
 			}
 			buffer, err := this.marshal(msg)
 			if err != nil {
-				logrus.WithError(err).Error("failed to encode message")
+				this.logger.WithError(err).WithField("msgType", msg.msgType).Error("failed to encode message")
 				continue
 			}
 			err = this.conn.WriteMessage(msg.wsMegType, buffer)
 			if err != nil {
-				logrus.WithError(err).Error("failed to write websocket message")
+				this.logger.WithError(err).Error("failed to write websocket message")
 				break loop
 			}
 		case <-ticker.C:
 			err := this.conn.WriteMessage(websocket.PingMessage, nil)
 			if err != nil {
-				logrus.WithError(err).Error("failed to ping ws client")
+				this.logger.WithError(err).Error("failed to ping ws client")
 				break loop
 			}
 		case <-this.done:
@@ -142,7 +148,7 @@ func (this *Conn) marshal(ctx *Context) ([]byte, error) {
 		binary.LittleEndian.PutUint16(header[4:6], 0) // 是否有错误
 		buffer, err := proto.Marshal(ctx.Data)
 		if err != nil {
-			logrus.WithError(err).Error("failed to encode message")
+			this.logger.WithError(err).Error("failed to encode message")
 			return nil, err
 		}
 		data = bytes.Join([][]byte{header, buffer}, []byte{})
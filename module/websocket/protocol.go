@@ -3,6 +3,7 @@ package websocket
 import (
 	"fmt"
 
+	"github.com/sirupsen/logrus"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -27,6 +28,11 @@ func (this *Context) SendError(err *Error) {
 	this.send(nil, err, 0)
 }
 
+// Logger 携带sessionId、requestId与msgType的日志
+func (this *Context) Logger() *logrus.Entry {
+	return this.conn.Logger().WithFields(logrus.Fields{"requestId": this.requestId, "msgType": this.msgType})
+}
+
 func (this *Context) send(data proto.Message, err *Error, isEnd uint16) {
 	this.conn.Write(&Context{wsMegType: this.wsMegType, requestId: this.requestId, msgType: this.msgType, isEnd: isEnd, Data: data, Error: err})
 }
@@ -4,6 +4,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	uuid "github.com/satori/go.uuid"
+	"github.com/sayuri567/tool/module/logger"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/protobuf/proto"
 )
@@ -33,7 +34,7 @@ func (this *WsHandler) handlerHttp(g *gin.Context, p interface{}) (interface{},
 	if err != nil {
 		return nil, err
 	}
-	wsConn, err := this.interceptor(conn)
+	wsConn, err := this.interceptor(conn, logger.FromContext(g))
 	if err != nil {
 		return nil, err
 	}
@@ -47,16 +48,17 @@ func (this *WsHandler) handlerHttp(g *gin.Context, p interface{}) (interface{},
 	return nil, nil
 }
 
-func (this *WsHandler) interceptor(conn *websocket.Conn) (*Conn, error) {
+func (this *WsHandler) interceptor(conn *websocket.Conn, entry *logrus.Entry) (*Conn, error) {
 	var err error
 
-	wsconn := &Conn{conn: conn, sessionId: uuid.NewV4().String()}
+	sessionId := uuid.NewV4().String()
+	wsconn := &Conn{conn: conn, sessionId: sessionId, logger: entry.WithField("sessionId", sessionId)}
 	if len(websocketModule.config.Interceptors) > 0 {
 		for _, interceptor := range websocketModule.config.Interceptors {
 			err = interceptor(wsconn)
 			if err != nil {
 				if closeErr := wsconn.conn.Close(); closeErr != nil {
-					logrus.WithError(closeErr).Error("failed to close wsconn")
+					wsconn.logger.WithError(closeErr).Error("failed to close wsconn")
 				}
 			}
 		}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const defaultCapacity = 10000
+
+// Store key-value缓存，api的接口缓存与model的按id缓存共用，redis实现见redispool.Cache
+type Store interface {
+	// Get 获取缓存，不存在或已过期时ok为false
+	Get(key string) (value []byte, ok bool, err error)
+	// Set 写入缓存，tags用于InvalidateTags批量失效
+	Set(key string, value []byte, ttl time.Duration, tags ...string) error
+	// Delete 删除缓存
+	Delete(keys ...string) error
+	// InvalidateTags 删除所有带有指定标签的缓存
+	InvalidateTags(tags ...string) error
+}
+
+// MemoryStore 进程内的LRU缓存
+type MemoryStore struct {
+	capacity int
+	items    map[string]*list.Element
+	lru      *list.List
+	tags     map[string]map[string]struct{}
+	lock     sync.Mutex
+}
+
+type memoryItem struct {
+	key      string
+	value    []byte
+	expireAt time.Time
+	tags     []string
+}
+
+// NewMemoryStore 创建LRU缓存，capacity为最大缓存条数，默认10000
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity < 1 {
+		capacity = defaultCapacity
+	}
+	return &MemoryStore{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		lru:      list.New(),
+		tags:     make(map[string]map[string]struct{}),
+	}
+}
+
+func (this *MemoryStore) Get(key string) ([]byte, bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	elem, ok := this.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	item := elem.Value.(*memoryItem)
+	if time.Now().After(item.expireAt) {
+		this.remove(elem)
+		return nil, false, nil
+	}
+	this.lru.MoveToFront(elem)
+	return item.value, true, nil
+}
+
+func (this *MemoryStore) Set(key string, value []byte, ttl time.Duration, tags ...string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if elem, ok := this.items[key]; ok {
+		this.remove(elem)
+	}
+	item := &memoryItem{key: key, value: value, expireAt: time.Now().Add(ttl), tags: tags}
+	this.items[key] = this.lru.PushFront(item)
+	for _, tag := range tags {
+		if _, ok := this.tags[tag]; !ok {
+			this.tags[tag] = make(map[string]struct{})
+		}
+		this.tags[tag][key] = struct{}{}
+	}
+	for this.lru.Len() > this.capacity {
+		this.remove(this.lru.Back())
+	}
+	return nil
+}
+
+func (this *MemoryStore) Delete(keys ...string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, key := range keys {
+		if elem, ok := this.items[key]; ok {
+			this.remove(elem)
+		}
+	}
+	return nil
+}
+
+func (this *MemoryStore) InvalidateTags(tags ...string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, tag := range tags {
+		for key := range this.tags[tag] {
+			if elem, ok := this.items[key]; ok {
+				this.remove(elem)
+			}
+		}
+		delete(this.tags, tag)
+	}
+	return nil
+}
+
+func (this *MemoryStore) remove(elem *list.Element) {
+	item := elem.Value.(*memoryItem)
+	this.lru.Remove(elem)
+	delete(this.items, item.key)
+	for _, tag := range item.tags {
+		if keys, ok := this.tags[tag]; ok {
+			delete(keys, item.key)
+			if len(keys) == 0 {
+				delete(this.tags, tag)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package fileutil
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -131,6 +132,31 @@ func CreateFile(path string, contents string, override ...bool) error {
 	return nil
 }
 
+// CreateFileFromReader 创建文件并写入reader中的内容，写入失败时删除文件
+func CreateFileFromReader(path string, reader io.Reader) (int64, error) {
+	if idx := strings.LastIndex(path, PathSep); idx > 0 {
+		if err := MakeDir(path[:idx]); err != nil {
+			return 0, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+
+	return size, nil
+}
+
 // DeleteFile 删除文件
 func DeleteFile(path string) error {
 	return os.Remove(path)